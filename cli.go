@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"main/utils"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+func commands() map[string]command {
+	return map[string]command{
+		"worker":  {"dequeue and process messages (default)", runWorker},
+		"enqueue": {"post a QueueRequest from a JSON file or flags", runEnqueue},
+		"bulk":    {"post QueueRequests from a JSONL file", runBulk},
+		"peek":    {"print decoded messages without dequeuing them", runPeek},
+		"purge":   {"delete every message in the queue", runPurge},
+		"stats":   {"print approximate message counts", runStats},
+		"replay":  {"move dead-lettered messages back to the queue", runReplay},
+		"sweep":   {"delete log blobs older than their KeepLogDays", runSweep},
+		"search":  {"find job logs by message ID, processor, status or date", runSearch},
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: "+os.Args[0]+" <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	cmds := commands()
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, cmds[name].usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run '"+os.Args[0]+" <command> -h' for the flags of a command.")
+}
+
+// queueFlags are the flags shared by every command.
+type queueFlags struct {
+	connString string
+	queueName  string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *queueFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	qf := &queueFlags{}
+	fs.StringVar(&qf.connString, "conn", "", "storage connection string (default $STORAGE_CONNECTION_STRING)")
+	fs.StringVar(&qf.queueName, "queue", GetQueueName(), "queue name, $QUEUE_NAME when set")
+	return fs, qf
+}
+
+// parse parses args into fs. The connection string is resolved afterwards
+// so that it never shows up in -h output.
+func (f *queueFlags) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if f.connString == "" {
+		f.connString = GetConn()
+	}
+	return nil
+}
+
+// keyValueFlag collects repeated key=value flags into a map.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f keyValueFlag) Set(value string) error {
+	for i := 0; i < len(value); i++ {
+		if value[i] == '=' {
+			f[value[:i]] = value[i+1:]
+			return nil
+		}
+	}
+	return fmt.Errorf("expected key=value, got %q", value)
+}
+
+// newLeaderLock builds the lock named by a -leader-lock value of the form
+// <container>/<blob>.
+func newLeaderLock(connString string, value string) (*utils.BlobLock, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid -leader-lock %q, want <container>/<blob>", value)
+	}
+	return utils.NewBlobLock(connString, parts[0], parts[1]), nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"main/processor"
+	"main/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// messageFlags are the flags that control how a message is sent.
+type messageFlags struct {
+	codec               string
+	encrypt             bool
+	claimCheckContainer string
+	delay               time.Duration
+}
+
+func newMessageFlags(fs *flag.FlagSet) *messageFlags {
+	mf := &messageFlags{}
+	fs.StringVar(&mf.codec, "codec", GetMessageCodec(), "compress the message, e.g. gzip, $MESSAGE_CODEC when set")
+	fs.BoolVar(&mf.encrypt, "encrypt", GetEncryptMessages(), "encrypt the message with the primary key, $ENCRYPT_MESSAGES when set")
+	fs.StringVar(&mf.claimCheckContainer, "claim-check-container", GetClaimCheckContainer(), "blob container for oversized messages, $CLAIM_CHECK_CONTAINER when set")
+	fs.DurationVar(&mf.delay, "delay", 0, "keep the message invisible for this long")
+	return mf
+}
+
+func (f *messageFlags) options() utils.MessageOptions {
+	return utils.MessageOptions{
+		ClaimCheckContainer: f.claimCheckContainer,
+		Codec:               f.codec,
+		Encrypt:             f.encrypt,
+		VisibilityTimeout:   f.delay,
+	}
+}
+
+func runEnqueue(args []string) error {
+	fs, qf := newFlagSet("enqueue")
+	mf := newMessageFlags(fs)
+
+	file := fs.String("file", "", "JSON file holding the QueueRequest, - for stdin")
+	params := keyValueFlag{}
+	fs.Var(params, "param", "request parameter as key=value, may be repeated")
+	requestTime := fs.String("request-time", "", "request time (default now, UTC)")
+	logConn := fs.String("log-conn", "", "log storage connection string (default -conn)")
+	logContainer := fs.String("log-container", "", "log container name")
+	logFile := fs.String("log-file", "", "log blob name; may use {date}, {time}, {messageId}, {processor}, {attempt}, {queue} and {param:key}")
+	keepLogDays := fs.Int("keep-log-days", 0, "days to keep the log blob")
+	logLevel := fs.String("log-level", "", "least severe level logged: debug, info, warn or error")
+	logFormat := fs.String("log-format", "", "log format: json or text")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+
+	var req processor.QueueRequest
+	if *file != "" {
+		var content []byte
+		var err error
+		if *file == "-" {
+			content, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			content, err = ioutil.ReadFile(*file)
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(content, &req); err != nil {
+			return errors.New(*file + ": " + err.Error())
+		}
+	}
+
+	if req.RequestQueueName == "" {
+		req.RequestQueueName = qf.queueName
+	}
+	if *requestTime != "" {
+		req.RequestTime = *requestTime
+	} else if req.RequestTime == "" {
+		req.RequestTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	if *logConn != "" {
+		req.LogStorageConnectionString = *logConn
+	} else if req.LogStorageConnectionString == "" {
+		req.LogStorageConnectionString = qf.connString
+	}
+	if *logContainer != "" {
+		req.LogContainerName = *logContainer
+	}
+	if *logFile != "" {
+		req.LogFileName = *logFile
+	}
+	if *keepLogDays != 0 {
+		req.KeepLogDays = *keepLogDays
+	}
+	if *logLevel != "" {
+		req.LogLevel = *logLevel
+	}
+	if *logFormat != "" {
+		req.LogFormat = *logFormat
+	}
+	if len(params) > 0 && req.Parameters == nil {
+		req.Parameters = make(map[string]string)
+	}
+	for k, v := range params {
+		req.Parameters[k] = v
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	messageId, err := utils.SendMessage(qf.connString, qf.queueName, string(data), mf.options())
+	if err != nil {
+		return err
+	}
+	fmt.Println("enqueued " + messageId)
+	return nil
+}
+
+func runBulk(args []string) error {
+	fs, qf := newFlagSet("bulk")
+	mf := newMessageFlags(fs)
+	file := fs.String("file", "", "JSONL file with one QueueRequest per line, - for stdin")
+	workers := fs.Int("workers", 4, "number of concurrent posts")
+	rate := fs.Float64("rate", 0, "maximum posts per second, 0 for no limit")
+	fromLine := fs.Int("from-line", 1, "first line to process, to resume an interrupted load")
+	dryRun := fs.Bool("dry-run", false, "validate every line without posting")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("bulk needs -file")
+	}
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	firstFailed := 0
+	ok, failed, err := processor.BulkEnqueue(in, processor.BulkEnqueueOptions{
+		ConnectionString: qf.connString,
+		QueueName:        qf.queueName,
+		MessageOptions:   mf.options(),
+		Workers:          *workers,
+		RatePerSecond:    *rate,
+		StartLine:        *fromLine,
+		DryRun:           *dryRun,
+	}, func(result processor.BulkEnqueueResult) {
+		switch {
+		case result.Err != nil:
+			if firstFailed == 0 || result.Line < firstFailed {
+				firstFailed = result.Line
+			}
+			fmt.Printf("line %d: FAILED %s\n", result.Line, result.Err.Error())
+		case *dryRun:
+			fmt.Printf("line %d: valid\n", result.Line)
+		default:
+			fmt.Printf("line %d: ok %s\n", result.Line, result.MessageId)
+		}
+	})
+
+	fmt.Printf("%d ok, %d failed\n", ok, failed)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d line(s) failed, the first at line %d", failed, firstFailed)
+	}
+	return nil
+}
+
+func runPeek(args []string) error {
+	fs, qf := newFlagSet("peek")
+	count := fs.Int("count", 32, "number of messages to show, at most 32")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+
+	messages, err := utils.PeekQueueMessages(qf.connString, qf.queueName, *count)
+	if err != nil && messages == nil {
+		return err
+	}
+	for _, msg := range messages {
+		fmt.Println(describeMessage(&msg))
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, []byte(msg.Body), "", "  ") == nil {
+			fmt.Println(pretty.String())
+		} else {
+			fmt.Println(msg.Body)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(strconv.Itoa(len(messages)) + " message(s)")
+	return nil
+}
+
+func describeMessage(msg *utils.QueueMessage) string {
+	s := "--- " + msg.MessageId + " inserted " + msg.InsertionTime + " dequeued " + strconv.Itoa(msg.DequeueCount) + " attempt " + strconv.Itoa(msg.Attempt())
+	if env := msg.Envelope; env != nil {
+		if env.Codec != "" {
+			s += " codec " + env.Codec
+		}
+		if env.KeyID != "" {
+			s += " key " + env.KeyID
+		}
+		if env.ClaimCheck != nil {
+			s += " claim-check " + env.ClaimCheck.Container + "/" + env.ClaimCheck.BlobName
+		}
+	}
+	return s
+}
+
+func runPurge(args []string) error {
+	fs, qf := newFlagSet("purge")
+	yes := fs.Bool("yes", false, "confirm deleting every message")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+	if !*yes {
+		return errors.New("refusing to purge " + qf.queueName + " without -yes")
+	}
+
+	if err := utils.OpenQueue(qf.connString, qf.queueName).Clear(); err != nil {
+		return err
+	}
+	fmt.Println("purged " + qf.queueName)
+	return nil
+}
+
+func runStats(args []string) error {
+	fs, qf := newFlagSet("stats")
+	deadLetter := fs.String("dead-letter", "", "dead-letter queue name (default <queue>-poison)")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+	if *deadLetter == "" {
+		*deadLetter = utils.DeadLetterQueue(qf.queueName)
+	}
+
+	for _, queueName := range []string{qf.queueName, *deadLetter} {
+		count, err := utils.OpenQueue(qf.connString, queueName).Count()
+		if err != nil {
+			fmt.Printf("%-32s %s\n", queueName, err.Error())
+			continue
+		}
+		fmt.Printf("%-32s %d\n", queueName, count)
+	}
+	return nil
+}
+
+func runReplay(args []string) error {
+	fs, qf := newFlagSet("replay")
+	from := fs.String("from", "", "queue to replay from (default <queue>-poison)")
+	max := fs.Int("max", 32, "maximum number of messages to move")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+	if *from == "" {
+		*from = utils.DeadLetterQueue(qf.queueName)
+	}
+
+	moved, err := processor.Replay(qf.connString, *from, qf.queueName, *max)
+	fmt.Println("replayed " + strconv.Itoa(moved) + " message(s) from " + *from + " to " + qf.queueName)
+	return err
+}
+
+func runSweep(args []string) error {
+	fs, qf := newFlagSet("sweep")
+	containers := fs.String("containers", "", "comma-separated log containers to sweep")
+	prefix := fs.String("prefix", "", "only sweep blobs whose name starts with this")
+	dryRun := fs.Bool("dry-run", false, "report expired logs without deleting them")
+	leaderLock := fs.String("leader-lock", GetLeaderLock(), "<container>/<blob> leased so only one instance sweeps at a time (default $LEADER_LOCK)")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+	if *containers == "" {
+		return errors.New("-containers is required")
+	}
+
+	if *leaderLock != "" && !*dryRun {
+		lock, err := newLeaderLock(qf.connString, *leaderLock)
+		if err != nil {
+			return err
+		}
+		if _, err := lock.TryLock(context.Background()); err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	opts := processor.LogRetentionOptions{
+		ConnectionString: qf.connString,
+		Containers:       strings.Split(*containers, ","),
+		Prefix:           *prefix,
+		DryRun:           *dryRun,
+	}
+	failed := 0
+	swept, err := processor.SweepLogs(opts, func(r processor.LogRetentionResult) {
+		status := "expired"
+		switch {
+		case r.Err != nil:
+			status = "FAILED: " + r.Err.Error()
+			failed++
+		case r.Deleted:
+			status = "deleted"
+		}
+		fmt.Println(r.Container + "/" + r.BlobName + " (expired " + r.ExpiresOn.Format(time.RFC3339) + "): " + status)
+	})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Println(strconv.Itoa(swept) + " expired logs")
+	} else {
+		fmt.Println(strconv.Itoa(swept) + " logs deleted")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d logs could not be deleted", failed)
+	}
+	return nil
+}
+
+func runSearch(args []string) error {
+	fs, qf := newFlagSet("search")
+	var search processor.LogSearch
+	fs.StringVar(&search.Container, "container", "", "only search this log container")
+	fs.StringVar(&search.MessageID, "message-id", "", "queue message ID of the job")
+	fs.StringVar(&search.Processor, "processor", "", "processor name, e.g. CurrencyConversionSync")
+	fs.StringVar(&search.Status, "status", "", processor.LogStatusSucceeded+" or "+processor.LogStatusFailed)
+	from := fs.String("from", "", "first day, as 2006-01-02")
+	to := fs.String("to", "", "last day, as 2006-01-02")
+	where := fs.String("where", "", "raw blob tag query, instead of the flags above")
+	namesOnly := fs.Bool("names-only", false, "print only the names of matching logs")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+
+	for _, day := range []struct {
+		value string
+		into  *time.Time
+	}{{*from, &search.From}, {*to, &search.To}} {
+		if day.value == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", day.value)
+		if err != nil {
+			return err
+		}
+		*day.into = t
+	}
+	if *where == "" {
+		var err error
+		if *where, err = search.Where(); err != nil {
+			return err
+		}
+	}
+
+	logs, err := utils.FindAllBlobsByTags(qf.connString, *where)
+	if err != nil {
+		return err
+	}
+	for _, log := range logs {
+		name := log.Container + "/" + log.Name
+		if *namesOnly {
+			fmt.Println(name)
+			continue
+		}
+		fmt.Println("== " + name + " ==")
+		get := utils.GetBlob(qf.connString, log.Container, log.Name)
+		if get.Error != nil {
+			return get.Error
+		}
+		if get.StatusCode != 200 {
+			fmt.Println("(download failed: " + strconv.Itoa(get.StatusCode) + ")")
+			continue
+		}
+		fmt.Println(strings.TrimRight(string(get.ResponseBody), "\n"))
+	}
+	return nil
+}
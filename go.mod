@@ -4,13 +4,13 @@ go 1.18
 
 require (
 	github.com/basgys/goxml2json v1.1.1-0.20181031222924-996d9fc8d313
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/jmoiron/sqlx v1.3.5
 	github.com/stretchr/objx v0.5.0
 )
 
 require (
 	github.com/bitly/go-simplejson v0.5.0 // indirect
-	github.com/go-sql-driver/mysql v1.7.1 // indirect
-	github.com/jmoiron/sqlx v1.3.5 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 )
@@ -1,49 +1,82 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
-	"main/processor"
 	"main/utils"
 	"os"
-	"time"
+	"strings"
 )
 
 func main() {
-	//TestPost()
-
-	connString := GetConn()
+	keyring, err := utils.LoadKeyringFromEnv()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	utils.MessageKeyring = keyring
 
-	for {
-		time.Sleep(time.Second * 5)
+	name, args := "worker", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-		go func() {
-			res := utils.DeQueue(connString, "demo1")
-			if res != nil {
+	cmd, ok := commands()[name]
+	if !ok {
+		printUsage()
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err == flag.ErrHelp {
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
 
-				var req processor.QueueRequest
-				if err := json.Unmarshal([]byte(res.ResponseBody), &req); err != nil {
-					fmt.Println("err")
-					return
-				}
-				go func() {
-					p := processor.NewCurrencyConversionSyncProcessor(req)
-					p.Start(p)
-				}()
-			}
-		}()
+func GetConn() string {
+	return os.Getenv("STORAGE_CONNECTION_STRING")
+}
 
+func GetQueueName() string {
+	if queueName := os.Getenv("QUEUE_NAME"); queueName != "" {
+		return queueName
 	}
+	return "demo1"
+}
 
+func GetScheduleFile() string {
+	return os.Getenv("SCHEDULE_FILE")
 }
 
-func GetConn() string {
-	return os.Getenv("STORAGE_CONNECTION_STRING")
+func GetLeaderLock() string {
+	return os.Getenv("LEADER_LOCK")
+}
+
+func GetCheckpointStore() string {
+	return os.Getenv("CHECKPOINT_STORE")
+}
+
+func GetLogConfig() string {
+	return os.Getenv("LOG_CONFIG")
+}
+
+func GetRetryConfig() string {
+	return os.Getenv("RETRY_CONFIG")
+}
+
+func GetClaimCheckContainer() string {
+	return os.Getenv("CLAIM_CHECK_CONTAINER")
+}
+
+func GetMessageCodec() string {
+	return os.Getenv("MESSAGE_CODEC")
+}
+
+func GetEncryptMessages() bool {
+	return os.Getenv("ENCRYPT_MESSAGES") == "true"
 }
 
-func TestPost() {
-	connString := GetConn()
-	data := "{}"
-	res := utils.PostQueue(connString, "demo1", data)
-	fmt.Println(string(res.ResponseBody))
+func GetDeleteClaimCheck() bool {
+	return os.Getenv("DELETE_CLAIM_CHECK") == "true"
 }
@@ -1,7 +1,11 @@
 package processor
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/utils"
+	"reflect"
 	"time"
 )
 
@@ -9,33 +13,106 @@ type AbstractProcessor struct {
 	queueRequest QueueRequest
 	logger       *QueueLogger
 	queueName    string
+	err          error
+
+	checkpoints CheckpointStore
+	jobID       string
 }
 
 func NewAbstractProcessor(queueRequest QueueRequest) *AbstractProcessor {
 	newLogger := NewQueueLogger(queueRequest)
 	queueName := queueRequest.RequestQueueName
-	return &AbstractProcessor{queueRequest: queueRequest, logger: newLogger, queueName: queueName}
+	return &AbstractProcessor{queueRequest: queueRequest, logger: newLogger, queueName: queueName, jobID: queueRequest.JobID}
 }
 
-func (f *AbstractProcessor) Start(overrideProcess OverrideProcess) {
+// Start runs the processor and reports whether the job failed, either by
+// panicking or by calling Fail.
+func (f *AbstractProcessor) Start(overrideProcess OverrideProcess) (err error) {
+	f.logger.job.Processor = processorName(overrideProcess)
 	defer func() {
 		if e := recover(); e != nil {
-			log.Fatal("Caught error: ", e)
+			err = fmt.Errorf("%v", e)
+			f.err = err
+			f.logger.Error("Caught error", "error", err)
+			f.PostProcessAction()
 		}
 	}()
 	f.PreProcessAction()
 	overrideProcess.Process()
+	if f.err == nil && f.checkpoints != nil && f.jobID != "" {
+		if err := f.checkpoints.Delete(f.jobID); err != nil {
+			f.logger.Warn("checkpoint delete failed", "error", err)
+		}
+	}
 	f.PostProcessAction()
 	f.LogAndCleanupAction()
+	return f.err
+}
+
+// Fail marks the job as failed so that it is retried once Process returns.
+func (f *AbstractProcessor) Fail(err error) {
+	f.logger.Error("Failed", "error", err)
+	f.err = err
+}
+
+// Logger is the job's logger. Use its Writer for libraries that log to an
+// io.Writer.
+func (f *AbstractProcessor) Logger() *QueueLogger {
+	return f.logger
+}
+
+// SetMessage records the queue message the job came from, so that its log
+// can be found by message ID.
+func (f *AbstractProcessor) SetMessage(msg *utils.QueueMessage) {
+	f.logger.job.MessageID = msg.MessageId
+	f.logger.job.Attempt = msg.Attempt()
+	if f.queueRequest.JobID == "" {
+		f.jobID = msg.JobID()
+	}
+}
+
+// SetCheckpointStore enables SaveCheckpoint and LoadCheckpoint. The job's
+// checkpoint is deleted once it succeeds.
+func (f *AbstractProcessor) SetCheckpointStore(store CheckpointStore) {
+	f.checkpoints = store
+}
+
+// SaveCheckpoint stores state as JSON, to be loaded by LoadCheckpoint when
+// the job is redelivered.
+func (f *AbstractProcessor) SaveCheckpoint(state interface{}) error {
+	if f.checkpoints == nil || f.jobID == "" {
+		return errors.New("no checkpoint store or job ID")
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return f.checkpoints.Save(f.jobID, content)
+}
+
+// LoadCheckpoint reads the last saved checkpoint into state and reports
+// whether there was one.
+func (f *AbstractProcessor) LoadCheckpoint(state interface{}) (bool, error) {
+	if f.checkpoints == nil || f.jobID == "" {
+		return false, nil
+	}
+	content, ok, err := f.checkpoints.Load(f.jobID)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, json.Unmarshal(content, state)
 }
 
 func (f *AbstractProcessor) PreProcessAction() {
-	f.logger.Log("Processor Processing Starting ..." + f.queueName)
-	f.logger.Log("startTime UTC: " + time.Now().UTC().String())
+	f.logger.Info("Processor Processing Starting", "startTime", time.Now())
 }
 
 func (f *AbstractProcessor) PostProcessAction() {
-	f.logger.Log("endTime UTC: " + time.Now().UTC().String())
+	f.logger.Info("Processor Processing Finished", "endTime", time.Now())
+	f.logger.job.Status = LogStatusSucceeded
+	if f.err != nil {
+		f.logger.job.Status = LogStatusFailed
+	}
 	f.logger.LogSave()
 }
 
@@ -45,3 +122,12 @@ func (f *AbstractProcessor) LogAndCleanupAction() {
 type OverrideProcess interface {
 	Process()
 }
+
+// processorName is the type name of the processor, e.g. CurrencyConversionSync.
+func processorName(overrideProcess OverrideProcess) string {
+	t := reflect.TypeOf(overrideProcess)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
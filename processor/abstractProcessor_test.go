@@ -0,0 +1,189 @@
+package processor_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"main/processor"
+	"main/utils"
+	"main/utils/storagetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testProcessor logs a secret and fails when fail is set.
+type testProcessor struct {
+	*processor.AbstractProcessor
+	fail bool
+}
+
+func (f *testProcessor) Process() {
+	f.Logger().Info("converting", "dsn", "user:hunter2@tcp(db:3306)/rates")
+	if f.fail {
+		f.Fail(errors.New("rates unavailable"))
+	}
+}
+
+// runJob receives the next job from the queue and runs it the way the
+// worker does. It returns the message and the name of the job's log blob.
+func runJob(t *testing.T, connString string, queueName string, fail bool) (*utils.QueueMessage, string) {
+	t.Helper()
+	msg, lease, err := utils.ReceiveMessage(connString, queueName)
+	if err != nil || msg == nil {
+		t.Fatalf("receive: %v %v", err, msg)
+	}
+	var req processor.QueueRequest
+	if err := json.Unmarshal([]byte(msg.Body), &req); err != nil {
+		t.Fatal(err)
+	}
+	before := logBlobs(t, req)
+
+	p := &testProcessor{AbstractProcessor: processor.NewAbstractProcessor(req), fail: fail}
+	p.SetMessage(msg)
+	if err := p.Start(p); err != nil {
+		if !fail {
+			t.Fatalf("job failed: %v", err)
+		}
+		policy := processor.RetryPolicy{Delays: []time.Duration{0}}
+		if err := processor.Retry(connString, queueName, msg, policy); err != nil {
+			t.Fatal(err)
+		}
+	} else if fail {
+		t.Fatal("failed job reported success")
+	}
+	if err := lease.Complete(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range logBlobs(t, req) {
+		if !before[name] {
+			return msg, name
+		}
+	}
+	t.Fatal("no log blob was written")
+	return nil, ""
+}
+
+func logBlobs(t *testing.T, req processor.QueueRequest) map[string]bool {
+	t.Helper()
+	blobs, _, err := utils.ListAllBlobs(req.LogStorageConnectionString, req.LogContainerName, utils.ListBlobsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, blob := range blobs {
+		names[blob.Name] = true
+	}
+	return names
+}
+
+func checkLog(t *testing.T, connString string, blobName string, msg *utils.QueueMessage, status string) {
+	t.Helper()
+	if !strings.Contains(blobName, "/testProcessor/") || !strings.Contains(blobName, msg.MessageId) {
+		t.Errorf("log name %q", blobName)
+	}
+
+	body, _, err := utils.GetBlobStream(connString, "logs", blobName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "converting") || !strings.Contains(string(content), "Processor Processing Finished") {
+		t.Errorf("log content %q", content)
+	}
+	if strings.Contains(string(content), "hunter2") {
+		t.Errorf("log shows the password: %q", content)
+	}
+
+	metadata, err := utils.GetBlobMetadata(connString, "logs", blobName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339, metadata[processor.LogExpiryMetadata]); err != nil {
+		t.Errorf("log expiry %q", metadata[processor.LogExpiryMetadata])
+	}
+	tags, err := utils.GetBlobTags(connString, "logs", blobName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags[processor.LogTagStatus] != status || tags[processor.LogTagMessageID] != msg.MessageId || tags[processor.LogTagProcessor] != "testProcessor" {
+		t.Errorf("log tags %v", tags)
+	}
+}
+
+func sendJob(t *testing.T, connString string, queueName string, appendBlob bool) {
+	t.Helper()
+	req := processor.QueueRequest{
+		RequestStorageConnectionString: connString,
+		RequestQueueName:               queueName,
+		LogStorageConnectionString:     connString,
+		LogContainerName:               "logs",
+		LogAppendBlob:                  appendBlob,
+		KeepLogDays:                    7,
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.SendMessage(connString, queueName, string(data), utils.MessageOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessorLog(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+	if _, err := utils.CreateContainerIfNotExists(connString, "logs"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, appendBlob := range []bool{false, true} {
+		sendJob(t, connString, "jobs", appendBlob)
+		msg, blobName := runJob(t, connString, "jobs", false)
+		checkLog(t, connString, blobName, msg, processor.LogStatusSucceeded)
+	}
+	if count, err := utils.GetQueueMessageCount(connString, "jobs"); err != nil || count != 0 {
+		t.Fatalf("count after jobs: %d %v", count, err)
+	}
+}
+
+func TestProcessorRetry(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+	if _, err := utils.CreateContainerIfNotExists(connString, "logs"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendJob(t, connString, "failing", false)
+	first, blobName := runJob(t, connString, "failing", true)
+	checkLog(t, connString, blobName, first, processor.LogStatusFailed)
+
+	retried, blobName := runJob(t, connString, "failing", true)
+	checkLog(t, connString, blobName, retried, processor.LogStatusFailed)
+	if retried.Attempt() != 1 || retried.JobID() != first.MessageId || retried.Body != first.Body {
+		t.Fatalf("retry has attempt %d and job ID %s", retried.Attempt(), retried.JobID())
+	}
+	if !strings.HasSuffix(blobName, "-1.log") {
+		t.Errorf("log name of the retry %q", blobName)
+	}
+
+	// the policy is used up, so the job moves to the dead-letter queue
+	if count, err := utils.GetQueueMessageCount(connString, "failing"); err != nil || count != 0 {
+		t.Fatalf("count after dead-lettering: %d %v", count, err)
+	}
+	dead, lease, err := utils.ReceiveMessage(connString, "failing-poison")
+	if err != nil || dead == nil {
+		t.Fatalf("dead letter: %v %v", err, dead)
+	}
+	defer lease.Complete()
+	if dead.Body != first.Body || dead.JobID() != first.MessageId {
+		t.Fatalf("dead letter %q of job %s", dead.Body, dead.JobID())
+	}
+}
@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BlobLogSink writes the log to a blob: a block blob that each write adds
+// a block to, or, with Append, an append blob that each write appends to.
+// Either way only the new records are uploaded and none are kept. The blob
+// carries its expiry from the first write, so the logs of jobs that crash
+// expire too; when the log is saved the expiry is renewed and the blob gets
+// its index tags.
+type BlobLogSink struct {
+	ConnectionString string
+	Container        string
+	// BlobName defaults to the job's LogName.
+	BlobName string
+	Append   bool
+
+	// blockIDs are the blocks the block blob log is committed from, and
+	// metadata, holding its expiry, is committed with them.
+	blockIDs []string
+	metadata map[string]string
+	// appendPosition is the length of the append blob log, or -1 before
+	// the blob has been created.
+	appendPosition int64
+	// containerReady is set once the log container is known to exist.
+	containerReady bool
+}
+
+func (f *BlobLogSink) Write(job *LogJob, records []LogRecord) error {
+	if f.BlobName == "" {
+		f.BlobName = job.LogName
+	}
+	var out strings.Builder
+	for _, record := range records {
+		out.WriteString(record.Line)
+	}
+	// nothing new since the blob was last written
+	if out.Len() == 0 && (f.blockIDs != nil || f.appendPosition >= 0) {
+		return nil
+	}
+
+	if !f.containerReady {
+		if _, err := utils.CreateContainerIfNotExists(f.ConnectionString, f.Container); err != nil {
+			return errors.New("create log container " + f.Container + ": " + err.Error())
+		}
+		f.containerReady = true
+	}
+
+	if !f.Append {
+		if err := f.addBlock(job, out.String()); err != nil {
+			return errors.New("upload of log " + f.Container + "/" + f.BlobName + " failed: " + err.Error())
+		}
+		return nil
+	}
+
+	if err := f.appendLog(job, out.String()); err != nil {
+		return errors.New("append to log " + f.Container + "/" + f.BlobName + " failed: " + err.Error())
+	}
+	return nil
+}
+
+func (f *BlobLogSink) Close(job *LogJob) error {
+	if f.BlobName == "" {
+		return nil
+	}
+	if err := markLogExpiry(job.Request, f.Container, f.BlobName, time.Now()); err != nil {
+		return errors.New("set expiry of log " + f.Container + "/" + f.BlobName + " failed: " + err.Error())
+	}
+	if err := saveLogTags(f.ConnectionString, f.Container, f.BlobName, job, time.Now()); err != nil {
+		return errors.New("set tags of log " + f.Container + "/" + f.BlobName + " failed: " + err.Error())
+	}
+	return nil
+}
+
+// addBlock stages out as a new block and commits the log with it. The
+// first call commits the blob even when out is empty.
+func (f *BlobLogSink) addBlock(job *LogJob, out string) error {
+	if f.blockIDs == nil {
+		f.metadata = logExpiry(job.Request, time.Now())
+	}
+	blockIDs := f.blockIDs
+	if out != "" {
+		if len(blockIDs) == utils.MaxBlocks {
+			return errors.New("log has reached the block limit of a blob")
+		}
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("log-%08d", len(blockIDs))))
+		if err := utils.PutBlock(f.ConnectionString, f.Container, f.BlobName, id, []byte(out)); err != nil {
+			return err
+		}
+		blockIDs = append(blockIDs, id)
+	}
+	headers := utils.BlobHTTPHeaders{ContentType: "text/plain; charset=utf-8"}
+	if err := utils.PutBlockList(f.ConnectionString, f.Container, f.BlobName, blockIDs, headers, f.metadata); err != nil {
+		return err
+	}
+	if blockIDs == nil {
+		blockIDs = []string{}
+	}
+	f.blockIDs = blockIDs
+	return nil
+}
+
+// appendLog appends out to the append blob log. The blob is created on the
+// first call, with its expiry; if another writer already created it or
+// appended to it, writing continues at its current end.
+func (f *BlobLogSink) appendLog(job *LogJob, out string) error {
+	if f.appendPosition < 0 {
+		now := time.Now()
+		err := utils.CreateAppendBlob(f.ConnectionString, f.Container, f.BlobName, false, logExpiry(job.Request, now))
+		if utils.ErrorStatus(err) == http.StatusConflict {
+			if err = f.refreshAppendPosition(); err == nil {
+				err = markLogExpiry(job.Request, f.Container, f.BlobName, now)
+			}
+		} else if err == nil {
+			f.appendPosition = 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	data := []byte(out)
+	for retried := false; len(data) > 0; retried = true {
+		start := f.appendPosition
+		length, err := utils.AppendBlob(f.ConnectionString, f.Container, f.BlobName, data, start)
+		data = data[length-start:]
+		f.appendPosition = length
+		if utils.ErrorStatus(err) != http.StatusPreconditionFailed || retried {
+			return err
+		}
+		if err := f.refreshAppendPosition(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *BlobLogSink) refreshAppendPosition() error {
+	props, err := utils.GetBlobProperties(f.ConnectionString, f.Container, f.BlobName)
+	if err != nil {
+		return err
+	}
+	f.appendPosition = props.ContentLength
+	return nil
+}
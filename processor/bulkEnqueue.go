@@ -0,0 +1,148 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"main/utils"
+	"sync"
+	"time"
+)
+
+// maxJSONLLineSize is the longest line BulkEnqueue accepts. Lines above the
+// queue message limit need a claim-check container to be sent.
+const maxJSONLLineSize = 16 * 1024 * 1024
+
+type BulkEnqueueOptions struct {
+	ConnectionString string
+	QueueName        string
+	MessageOptions   utils.MessageOptions
+	// Workers is the number of concurrent posts. Defaults to 4.
+	Workers int
+	// RatePerSecond caps the number of posts per second; 0 means no limit.
+	RatePerSecond float64
+	// StartLine skips every line before it, to resume an interrupted load.
+	// Lines are numbered from 1.
+	StartLine int
+	// DryRun validates every line without posting anything.
+	DryRun bool
+}
+
+// BulkEnqueueResult is the outcome of one line.
+type BulkEnqueueResult struct {
+	Line      int
+	MessageId string
+	Err       error
+}
+
+type bulkEnqueueJob struct {
+	line int
+	data string
+}
+
+// BulkEnqueue streams QueueRequest objects, one JSON document per line,
+// from r and posts them to the queue. report is called once per non-blank
+// line, from a single goroutine, as results complete. It returns the
+// number of lines that succeeded and failed.
+func BulkEnqueue(r io.Reader, opts BulkEnqueueOptions, report func(BulkEnqueueResult)) (ok int, failed int, err error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+
+	var tick <-chan time.Time
+	if opts.RatePerSecond > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.RatePerSecond))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	jobs := make(chan bulkEnqueueJob)
+	results := make(chan BulkEnqueueResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- postBulkLine(job, opts)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for result := range results {
+			if result.Err != nil {
+				failed++
+			} else {
+				ok++
+			}
+			if report != nil {
+				report(result)
+			}
+		}
+		close(done)
+	}()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxJSONLLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line < opts.StartLine {
+			continue
+		}
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		data, verr := validateBulkLine(data)
+		if verr != nil {
+			results <- BulkEnqueueResult{Line: line, Err: verr}
+			continue
+		}
+		if opts.DryRun {
+			results <- BulkEnqueueResult{Line: line}
+			continue
+		}
+		if tick != nil {
+			<-tick
+		}
+		jobs <- bulkEnqueueJob{line: line, data: string(data)}
+	}
+	err = scanner.Err()
+
+	close(jobs)
+	wg.Wait()
+	close(results)
+	<-done
+	return ok, failed, err
+}
+
+// validateBulkLine checks one line and returns it re-encoded, which drops
+// insignificant whitespace.
+func validateBulkLine(data []byte) ([]byte, error) {
+	var req QueueRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(req)
+}
+
+func postBulkLine(job bulkEnqueueJob, opts BulkEnqueueOptions) BulkEnqueueResult {
+	messageId, err := utils.SendMessage(opts.ConnectionString, opts.QueueName, job.data, opts.MessageOptions)
+	return BulkEnqueueResult{Line: job.line, MessageId: messageId, Err: err}
+}
@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"database/sql"
+	"errors"
+	"main/utils"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CheckpointStore keeps the JSON state of a job between deliveries, so that
+// a redelivered job can resume where the last attempt stopped.
+type CheckpointStore interface {
+	Save(jobID string, state []byte) error
+	// Load returns false when the job has no checkpoint.
+	Load(jobID string) ([]byte, bool, error)
+	Delete(jobID string) error
+}
+
+// OpenCheckpointStore returns the store named by spec: blob:<container> for
+// blobs in the storage account, or sql:<table> for a MySQL table.
+func OpenCheckpointStore(spec string, storageConnString string, sqlConnString string) (CheckpointStore, error) {
+	kind, name := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, name = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "blob":
+		if name == "" {
+			return nil, errors.New("checkpoint store blob:<container> needs a container")
+		}
+		return &BlobCheckpointStore{ConnectionString: storageConnString, Container: name}, nil
+	case "sql":
+		if name == "" {
+			name = "job_checkpoint"
+		}
+		return NewSQLCheckpointStore(sqlConnString, name)
+	}
+	return nil, errors.New("unknown checkpoint store " + spec + ", want blob:<container> or sql:<table>")
+}
+
+// BlobCheckpointStore keeps each checkpoint in the blob <Prefix><jobID>.json.
+type BlobCheckpointStore struct {
+	ConnectionString string
+	Container        string
+	Prefix           string
+}
+
+func (f *BlobCheckpointStore) Save(jobID string, state []byte) error {
+	opts := utils.PutBlobOptions{Headers: utils.BlobHTTPHeaders{ContentType: "application/json"}}
+	post := utils.PutBlob(f.ConnectionString, f.Container, f.blobName(jobID), string(state), opts)
+	if post.Error == nil && post.StatusCode == http.StatusNotFound {
+		if _, err := utils.CreateContainerIfNotExists(f.ConnectionString, f.Container); err != nil {
+			return err
+		}
+		post = utils.PutBlob(f.ConnectionString, f.Container, f.blobName(jobID), string(state), opts)
+	}
+	if post.Error != nil {
+		return post.Error
+	}
+	if post.StatusCode != http.StatusCreated {
+		return errors.New("checkpoint save failed: " + strconv.Itoa(post.StatusCode))
+	}
+	return nil
+}
+
+func (f *BlobCheckpointStore) Load(jobID string) ([]byte, bool, error) {
+	get := utils.GetBlob(f.ConnectionString, f.Container, f.blobName(jobID))
+	if get.Error != nil {
+		return nil, false, get.Error
+	}
+	switch get.StatusCode {
+	case http.StatusOK:
+		return get.ResponseBody, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	}
+	return nil, false, errors.New("checkpoint load failed: " + strconv.Itoa(get.StatusCode))
+}
+
+func (f *BlobCheckpointStore) Delete(jobID string) error {
+	delete := utils.DeleteBlob(f.ConnectionString, f.Container, f.blobName(jobID))
+	if delete.Error != nil {
+		return delete.Error
+	}
+	if delete.StatusCode != http.StatusAccepted && delete.StatusCode != http.StatusNotFound {
+		return errors.New("checkpoint delete failed: " + strconv.Itoa(delete.StatusCode))
+	}
+	return nil
+}
+
+func (f *BlobCheckpointStore) blobName(jobID string) string {
+	return f.Prefix + jobID + ".json"
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLCheckpointStore keeps checkpoints in a MySQL table, created on first
+// use:
+//
+//	CREATE TABLE job_checkpoint (
+//	    job_id     VARCHAR(255) NOT NULL PRIMARY KEY,
+//	    state      MEDIUMTEXT   NOT NULL,
+//	    updated_at DATETIME     NOT NULL
+//	)
+type SQLCheckpointStore struct {
+	connString string
+	table      string
+
+	lock    sync.Mutex
+	created bool
+}
+
+func NewSQLCheckpointStore(connString string, table string) (*SQLCheckpointStore, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, errors.New("invalid checkpoint table name " + table)
+	}
+	return &SQLCheckpointStore{connString: connString, table: table}, nil
+}
+
+func (f *SQLCheckpointStore) Save(jobID string, state []byte) error {
+	if err := f.createTable(); err != nil {
+		return err
+	}
+	_, _, err := utils.SQLExec(f.connString, false,
+		"INSERT INTO "+f.table+" (job_id, state, updated_at) VALUES (?, ?, UTC_TIMESTAMP()) "+
+			"ON DUPLICATE KEY UPDATE state = VALUES(state), updated_at = VALUES(updated_at)",
+		jobID, string(state))
+	return err
+}
+
+func (f *SQLCheckpointStore) Load(jobID string) ([]byte, bool, error) {
+	if err := f.createTable(); err != nil {
+		return nil, false, err
+	}
+	var state string
+	err := utils.SQLQuery(&state, f.connString, "SELECT state FROM "+f.table+" WHERE job_id = ?", jobID)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(state), true, nil
+}
+
+func (f *SQLCheckpointStore) Delete(jobID string) error {
+	if err := f.createTable(); err != nil {
+		return err
+	}
+	_, _, err := utils.SQLExec(f.connString, false, "DELETE FROM "+f.table+" WHERE job_id = ?", jobID)
+	return err
+}
+
+func (f *SQLCheckpointStore) createTable() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.created {
+		return nil
+	}
+	_, _, err := utils.SQLExec(f.connString, false, "CREATE TABLE IF NOT EXISTS "+f.table+" ("+
+		"job_id VARCHAR(255) NOT NULL PRIMARY KEY, "+
+		"state MEDIUMTEXT NOT NULL, "+
+		"updated_at DATETIME NOT NULL)")
+	f.created = err == nil
+	return err
+}
@@ -15,9 +15,9 @@ func NewCurrencyConversionSyncProcessor(queueRequest QueueRequest) *CurrencyConv
 }
 
 func (f *CurrencyConversionSync) Process() {
-	f.logger.Log("processing ...  " + f.AbstractProcessor.queueName)
+	f.logger.Info("processing")
 	s, _ := json.Marshal((f.AbstractProcessor.queueRequest))
-	f.logger.Log("Request JObject" + string(s))
+	f.logger.Info("request", "request", string(s))
 
 	// get conversion ratio
 	URI := "https://openexchangerates.org/api/historical/2023-01-01.json?app_id=abba26dd7c8c40448b5006a312a8a411&base=USD"
@@ -27,9 +27,10 @@ func (f *CurrencyConversionSync) Process() {
 	}
 	err := utils.HttpGetRequest(get)
 	if err != nil {
-		f.logger.Log(err.Error())
+		f.Fail(err)
+		return
 	}
-	f.logger.Log("raw data" + string(get.ResponseBody)[:250])
+	f.logger.Debug("raw data", "body", string(get.ResponseBody)[:250])
 
 	// var model []interface{}
 	// queryString := `SELECT * FROM Table `
@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileLogSink appends the records of every job on a queue to one local
+// file, which is rotated by size and age. It keeps a copy of the logs when
+// storage is unreachable.
+type FileLogSink struct {
+	file *rotatingFile
+}
+
+var (
+	rotatingFilesLock sync.Mutex
+	// rotatingFiles are shared by all sinks writing to the same path.
+	rotatingFiles = make(map[string]*rotatingFile)
+)
+
+func newFileLogSink(config LogSinkConfig, req QueueRequest) (*FileLogSink, error) {
+	name := config.Name
+	if name == "" {
+		name = req.RequestQueueName + ".log"
+		if req.RequestQueueName == "" {
+			name = "jobs.log"
+		}
+	}
+	path, err := filepath.Abs(filepath.Join(config.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	rotatingFilesLock.Lock()
+	defer rotatingFilesLock.Unlock()
+	file := rotatingFiles[path]
+	if file == nil {
+		rotateEvery, _ := time.ParseDuration(config.RotateEvery)
+		file = &rotatingFile{
+			path:        path,
+			maxSize:     int64(config.MaxSizeKB) * 1024,
+			rotateEvery: rotateEvery,
+			maxFiles:    config.MaxFiles,
+		}
+		rotatingFiles[path] = file
+	}
+	return &FileLogSink{file: file}, nil
+}
+
+func (f *FileLogSink) Write(job *LogJob, records []LogRecord) error {
+	var out strings.Builder
+	for _, record := range records {
+		out.WriteString(record.Line)
+	}
+	return f.file.write([]byte(out.String()))
+}
+
+func (f *FileLogSink) Close(job *LogJob) error {
+	return nil
+}
+
+type rotatingFile struct {
+	path        string
+	maxSize     int64
+	rotateEvery time.Duration
+	maxFiles    int
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func (f *rotatingFile) write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	now := time.Now()
+	if f.file != nil && ((f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize) ||
+		(f.rotateEvery > 0 && now.Sub(f.opened) >= f.rotateEvery)) {
+		if err := f.rotate(now); err != nil {
+			return err
+		}
+	}
+	if f.file == nil {
+		file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		f.file, f.size, f.opened = file, info.Size(), now
+	}
+
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return err
+}
+
+// rotate renames the current file with a timestamp suffix and removes the
+// oldest rotated files beyond maxFiles.
+func (f *rotatingFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if err := os.Rename(f.path, f.path+"."+now.UTC().Format("20060102T150405.000000")); err != nil {
+		return err
+	}
+	if f.maxFiles <= 0 {
+		return nil
+	}
+
+	rotated, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(rotated)
+	for len(rotated) > f.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// DefaultLogFileName is the log blob name of requests that set
+// LogContainerName but no LogFileName: one folder per day.
+const DefaultLogFileName = "{date:2006/01/02}/{processor}/{time:150405}-{messageId}-{attempt}.log"
+
+// logNamePattern matches the placeholders of a log name: {date} and
+// {time}, either with an offset in days and a Go layout such as
+// {date-1:20060102}, {messageId}, {processor}, {attempt}, {queue} and
+// {param:key}. The syntax of {date} and {time} is the scheduler's, but
+// {time} defaults to 150405, as blob names should not contain colons.
+var logNamePattern = regexp.MustCompile(`\{(\w+)([+-]\d+)?(?::([^}]+))?\}`)
+
+// validateLogName reports placeholders expandLogName does not know.
+func validateLogName(name string) error {
+	for _, groups := range logNamePattern.FindAllStringSubmatch(name, -1) {
+		if groups[2] != "" && groups[1] != "date" && groups[1] != "time" {
+			return errors.New("log name placeholder " + groups[0] + " takes no offset")
+		}
+		switch groups[1] {
+		case "date", "time", "param":
+		case "messageId", "processor", "attempt", "queue":
+			if groups[3] != "" {
+				return errors.New("log name placeholder " + groups[0] + " takes no argument")
+			}
+		default:
+			return errors.New("unknown log name placeholder " + groups[0])
+		}
+		if groups[1] == "param" && groups[3] == "" {
+			return errors.New("log name placeholder {param} needs a key, e.g. {param:date}")
+		}
+	}
+	return nil
+}
+
+// expandLogName expands the request's LogFileName, or DefaultLogFileName,
+// for the job started at start. Dates and times are in UTC. The name is
+// plain text: a '/' in a value starts a folder, and the blob URL escapes
+// everything else.
+func expandLogName(job *LogJob, start time.Time) string {
+	name := job.Request.LogFileName
+	if name == "" {
+		name = DefaultLogFileName
+	}
+	return logNamePattern.ReplaceAllStringFunc(name, func(match string) string {
+		groups := logNamePattern.FindStringSubmatch(match)
+		switch groups[1] {
+		case "date", "time":
+			t := start.UTC()
+			if groups[2] != "" {
+				days, _ := strconv.Atoi(groups[2])
+				t = t.AddDate(0, 0, days)
+			}
+			layout := groups[3]
+			if layout == "" {
+				layout = "2006-01-02"
+				if groups[1] == "time" {
+					layout = "150405"
+				}
+			}
+			return t.Format(layout)
+		case "messageId":
+			return job.MessageID
+		case "processor":
+			return job.Processor
+		case "attempt":
+			return strconv.Itoa(job.Attempt)
+		case "queue":
+			return job.Request.RequestQueueName
+		case "param":
+			return job.Request.Parameters[groups[3]]
+		}
+		return match
+	})
+}
@@ -0,0 +1,31 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandLogName(t *testing.T) {
+	start := time.Date(2026, 3, 1, 4, 5, 6, 0, time.FixedZone("CET", 3600))
+	job := &LogJob{
+		Request: QueueRequest{
+			RequestQueueName: "rates",
+			Parameters:       map[string]string{"date": "2026-02-28", "odd": "a/b c?#%"},
+		},
+		MessageID: "m1",
+		Processor: "CurrencyConversionSync",
+		Attempt:   2,
+	}
+	for _, c := range []struct{ name, want string }{
+		{"", "2026/03/01/CurrencyConversionSync/030506-m1-2.log"},
+		{"{date}/{queue}.log", "2026-03-01/rates.log"},
+		{"{date-1:20060102}-{time:1504}.log", "20260228-0305.log"},
+		{"{param:date}/{param:odd}.log", "2026-02-28/a/b c?#%.log"},
+		{"{param:missing}{messageId}", "m1"},
+	} {
+		job.Request.LogFileName = c.name
+		if got := expandLogName(job, start); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, errors.New("unknown log level " + s)
+}
+
+// Log formats.
+const (
+	// LogFormatJSON writes one JSON object per line.
+	LogFormatJSON = "json"
+	// LogFormatText writes "<time> <LEVEL> <message> key=value ...".
+	LogFormatText = "text"
+)
+
+// logField is one key-value pair of a log record.
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// logFields pairs up alternating keys and values. A key without a value,
+// or a value whose key is not a string, is kept under !BADKEY.
+func logFields(keyValues []interface{}) []logField {
+	fields := make([]logField, 0, (len(keyValues)+1)/2)
+	for i := 0; i < len(keyValues); i += 2 {
+		key, ok := keyValues[i].(string)
+		if !ok || i+1 == len(keyValues) {
+			fields = append(fields, logField{key: "!BADKEY", value: keyValues[i]})
+			i--
+			continue
+		}
+		fields = append(fields, logField{key: key, value: keyValues[i+1]})
+	}
+	return fields
+}
+
+// formatLogRecord renders a record as one line without the trailing newline.
+func formatLogRecord(format string, t time.Time, level LogLevel, msg string, fields []logField) string {
+	if format == LogFormatText {
+		var b strings.Builder
+		b.WriteString(t.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+		b.WriteString(" " + strings.ToUpper(level.String()) + " ")
+		b.WriteString(msg)
+		for _, field := range fields {
+			b.WriteString(" " + field.key + "=" + textLogValue(field.value))
+		}
+		return b.String()
+	}
+
+	var b bytes.Buffer
+	write := func(key string, value interface{}) {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(jsonLogValue(value))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	write("time", t.UTC().Format(time.RFC3339Nano))
+	write("level", level.String())
+	write("msg", msg)
+	for _, field := range fields {
+		write(field.key, field.value)
+	}
+	return "{" + b.String() + "}"
+}
+
+func jsonLogValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+func textLogValue(value interface{}) string {
+	s := fmt.Sprint(jsonLogValue(value))
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RedactedValue replaces secrets in job logs.
+const RedactedValue = "***"
+
+// secretRequestFields are the QueueRequest fields holding secrets.
+var secretRequestFields = []string{"DBConnectionStrng", "RequestStorageConnectionString", "LogStorageConnectionString"}
+
+// redactPatterns mask their first group, or the whole match without one:
+// the values of secret QueueRequest fields in JSON, even when the JSON is
+// itself a quoted string, AccountKey= and Password= in connection strings,
+// SAS sig= and app_id= query parameters and the password of a MySQL DSN.
+var redactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\\*"(?:` + strings.Join(secretRequestFields, "|") + `)\\*"\s*:\s*\\*"((?:[^"\\]|\\+u[0-9a-fA-F]{4})+)\\*"`),
+	regexp.MustCompile(`(?i)\b(?:AccountKey|Password|Pwd)=([^;&"\s\\]+)`),
+	regexp.MustCompile(`(?i)(?:sig|app_id)=([^;&"\s\\]+)`),
+	regexp.MustCompile(`[^\s:/@"]+:([^\s@/"]+)@(?:tcp|unix)\(`),
+}
+
+// logRedactor masks secrets in log lines before they reach any sink.
+type logRedactor struct {
+	// secrets are the request's secret values, as written and as escaped
+	// in JSON and quoted text.
+	secrets  []string
+	patterns []*regexp.Regexp
+}
+
+func newLogRedactor(req QueueRequest, patterns []*regexp.Regexp) *logRedactor {
+	f := &logRedactor{patterns: append(append([]*regexp.Regexp(nil), redactPatterns...), patterns...)}
+	for _, secret := range []string{req.DBConnectionStrng, req.RequestStorageConnectionString, req.LogStorageConnectionString} {
+		if secret == "" {
+			continue
+		}
+		escaped := escapeJSON(secret)
+		f.secrets = append(f.secrets, secret, escaped, escapeJSON(escaped), quoteText(secret), quoteText(escaped))
+	}
+	return f
+}
+
+func (f *logRedactor) redact(s string) string {
+	for _, secret := range f.secrets {
+		s = strings.Replace(s, secret, RedactedValue, -1)
+	}
+	for _, pattern := range f.patterns {
+		s = redactMatches(pattern, s)
+	}
+	return s
+}
+
+// redactMatches replaces the first group of each match of pattern in s, or
+// the whole match when pattern has no groups.
+func redactMatches(pattern *regexp.Regexp, s string) string {
+	matches := pattern.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) > 2 {
+			start, end = m[2], m[3]
+		}
+		if start < 0 || s[start:end] == RedactedValue {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(RedactedValue)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// escapeJSON and quoteText escape s as it appears inside a JSON string and
+// a quoted text log value.
+func escapeJSON(s string) string {
+	escaped, _ := json.Marshal(s)
+	return string(escaped[1 : len(escaped)-1])
+}
+
+func quoteText(s string) string {
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"errors"
+	"main/utils"
+	"strconv"
+	"time"
+)
+
+// LogExpiryMetadata is the metadata key holding the RFC3339 time after
+// which a log blob may be deleted.
+const LogExpiryMetadata = "expireson"
+
+// logExpiry returns the metadata of a log blob that expires KeepLogDays
+// after now, or nil for logs with KeepLogDays of 0, which are kept forever.
+func logExpiry(req QueueRequest, now time.Time) map[string]string {
+	if req.KeepLogDays <= 0 {
+		return nil
+	}
+	return map[string]string{LogExpiryMetadata: now.UTC().AddDate(0, 0, req.KeepLogDays).Format(time.RFC3339)}
+}
+
+// markLogExpiry records when an existing log blob expires, KeepLogDays
+// after now.
+func markLogExpiry(req QueueRequest, container string, blobName string, now time.Time) error {
+	expiry := logExpiry(req, now)
+	if expiry == nil || container == "" {
+		return nil
+	}
+	connString := req.LogStorageConnectionString
+	metadata, err := utils.GetBlobMetadata(connString, container, blobName)
+	if err != nil {
+		return err
+	}
+	metadata[LogExpiryMetadata] = expiry[LogExpiryMetadata]
+	return utils.SetBlobMetadata(connString, container, blobName, metadata)
+}
+
+type LogRetentionOptions struct {
+	ConnectionString string
+	Containers       []string
+	// Prefix limits the sweep to blobs whose name starts with it.
+	Prefix string
+	// DryRun reports expired blobs without deleting them.
+	DryRun bool
+	// Now defaults to the current time.
+	Now time.Time
+}
+
+type LogRetentionResult struct {
+	Container string
+	BlobName  string
+	ExpiresOn time.Time
+	Deleted   bool
+	Err       error
+}
+
+// SweepLogs deletes the log blobs of opts.Containers whose expiry has
+// passed and calls report for each of them. Blobs without an expiry are
+// left alone. It returns the number of blobs deleted, or found in a dry run.
+func SweepLogs(opts LogRetentionOptions, report func(LogRetentionResult)) (int, error) {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	swept := 0
+	for _, container := range opts.Containers {
+		blobs, _, err := utils.ListAllBlobs(opts.ConnectionString, container, utils.ListBlobsOptions{Prefix: opts.Prefix, IncludeMetadata: true})
+		if err != nil {
+			return swept, err
+		}
+		for _, blob := range blobs {
+			if blob.Properties == nil {
+				continue
+			}
+			expiresOn, err := time.Parse(time.RFC3339, blob.Properties.Metadata[LogExpiryMetadata])
+			if err != nil || expiresOn.After(now) {
+				continue
+			}
+
+			result := LogRetentionResult{Container: container, BlobName: blob.Name, ExpiresOn: expiresOn}
+			if !opts.DryRun {
+				result.Err = deleteBlob(opts.ConnectionString, container, blob.Name)
+				result.Deleted = result.Err == nil
+			}
+			if result.Err == nil {
+				swept++
+			}
+			if report != nil {
+				report(result)
+			}
+		}
+	}
+	return swept, nil
+}
+
+func deleteBlob(connString string, container string, blobName string) error {
+	delete := utils.DeleteBlob(connString, container, blobName)
+	if delete.Error != nil {
+		return delete.Error
+	}
+	if delete.StatusCode != 202 && delete.StatusCode != 404 {
+		return errors.New("log delete failed: " + strconv.Itoa(delete.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"main/utils"
+	"os"
+	"regexp"
+	"time"
+)
+
+// LogRecord is one formatted log line of a job.
+type LogRecord struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	// Line is the record in the logger's format, ending in a newline.
+	Line string
+}
+
+// LogJob describes the job a log belongs to.
+type LogJob struct {
+	Request   QueueRequest
+	MessageID string
+	Processor string
+	Attempt   int
+	// Status is set when the log is saved.
+	Status string
+	// LogName is the request's LogFileName with its placeholders
+	// expanded, set before the first Write.
+	LogName string
+}
+
+// LogSink stores the records of a job log. A QueueLogger writes to each of
+// its sinks in turn; a failing sink does not keep records from the others.
+type LogSink interface {
+	// Write receives the records logged since the previous Write.
+	Write(job *LogJob, records []LogRecord) error
+	// Close is called once, after the last Write, when the log is saved.
+	Close(job *LogJob) error
+}
+
+// UnbufferedLogSink is a LogSink that is written every record as soon as
+// it is logged instead of when the log is flushed.
+type UnbufferedLogSink interface {
+	LogSink
+	Unbuffered()
+}
+
+// LogSinkConfig configures one sink.
+type LogSinkConfig struct {
+	// Type is blob, appendblob, file, console or sql. The blob sinks write
+	// to the job's LogName in the request's LogContainerName.
+	Type string `json:"type"`
+
+	// Dir and Name place a file sink's log at Dir/Name, by default
+	// <queue>.log. Rotated files get a timestamp suffix.
+	Dir  string `json:"dir,omitempty"`
+	Name string `json:"name,omitempty"`
+	// MaxSizeKB and RotateEvery, e.g. "24h", start a new file once the
+	// current one is that large or old. MaxFiles rotated files are kept.
+	MaxSizeKB   int    `json:"maxSizeKB,omitempty"`
+	RotateEvery string `json:"rotateEvery,omitempty"`
+	MaxFiles    int    `json:"maxFiles,omitempty"`
+
+	// Table is the sql sink's table, job_log by default. ConnectionString
+	// defaults to $SQLCONNECTSTRING.
+	Table            string `json:"table,omitempty"`
+	ConnectionString string `json:"connectionString,omitempty"`
+}
+
+// LogConfig picks the sinks of a job by its queue and sets how often
+// buffered sinks are written.
+type LogConfig struct {
+	// Queues maps a queue name to its sinks.
+	Queues map[string][]LogSinkConfig `json:"queues,omitempty"`
+	// Default applies to queues not in Queues.
+	Default []LogSinkConfig `json:"default,omitempty"`
+
+	// FlushInterval, e.g. "5s", is the longest records wait before they
+	// are flushed. FlushSizeKB flushes sooner once that much is waiting.
+	FlushInterval string `json:"flushInterval,omitempty"`
+	FlushSizeKB   int    `json:"flushSizeKB,omitempty"`
+	// MaxBufferKB bounds the records waiting for slow sinks; the oldest
+	// are dropped beyond it.
+	MaxBufferKB int `json:"maxBufferKB,omitempty"`
+
+	// Redact lists regular expressions masked in every log line on top of
+	// the built-in secret patterns. A pattern with a group masks only its
+	// first group, e.g. "token=(\\w+)".
+	Redact []string `json:"redact,omitempty"`
+}
+
+// Flush defaults, used when LogConfig leaves them unset.
+const (
+	DefaultLogFlushInterval = 5 * time.Second
+	DefaultLogFlushSize     = 64 * 1024
+	DefaultLogMaxBuffer     = 8 * 1024 * 1024
+)
+
+// flushSettings returns the flush interval, flush size and buffer bound.
+func (f *LogConfig) flushSettings() (time.Duration, int, int) {
+	interval, flushSize, maxBuffer := DefaultLogFlushInterval, DefaultLogFlushSize, DefaultLogMaxBuffer
+	if f == nil {
+		return interval, flushSize, maxBuffer
+	}
+	if d, err := time.ParseDuration(f.FlushInterval); err == nil && d > 0 {
+		interval = d
+	}
+	if f.FlushSizeKB > 0 {
+		flushSize = f.FlushSizeKB * 1024
+	}
+	if f.MaxBufferKB > 0 {
+		maxBuffer = f.MaxBufferKB * 1024
+	}
+	return interval, flushSize, maxBuffer
+}
+
+// redactPatterns compiles Redact, skipping patterns that do not compile;
+// LoadLogConfig rejects those.
+func (f *LogConfig) redactPatterns() []*regexp.Regexp {
+	if f == nil {
+		return nil
+	}
+	var patterns []*regexp.Regexp
+	for _, expr := range f.Redact {
+		if pattern, err := regexp.Compile(expr); err == nil {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// JobLogConfig, when set, chooses the sinks of every new QueueLogger.
+// Without it, logs go to the console and to the request's log blob.
+var JobLogConfig *LogConfig
+
+func LoadLogConfig(path string) (*LogConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config LogConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	if config.FlushInterval != "" {
+		if _, err := time.ParseDuration(config.FlushInterval); err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+	}
+	for _, expr := range config.Redact {
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, errors.New(path + ": redact: " + err.Error())
+		}
+	}
+	for _, sinks := range append([][]LogSinkConfig{config.Default}, mapValues(config.Queues)...) {
+		for _, sink := range sinks {
+			if err := sink.validate(); err != nil {
+				return nil, errors.New(path + ": " + err.Error())
+			}
+		}
+	}
+	return &config, nil
+}
+
+func mapValues(m map[string][]LogSinkConfig) [][]LogSinkConfig {
+	values := make([][]LogSinkConfig, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+func (f LogSinkConfig) validate() error {
+	switch f.Type {
+	case "blob", "appendblob", "console":
+		return nil
+	case "file":
+		if f.Dir == "" {
+			return errors.New("file log sink needs a dir")
+		}
+		if f.RotateEvery != "" {
+			if _, err := time.ParseDuration(f.RotateEvery); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "sql":
+		if f.Table != "" && !sqlIdentifier.MatchString(f.Table) {
+			return errors.New("invalid log table name " + f.Table)
+		}
+		return nil
+	}
+	return errors.New("unknown log sink type " + f.Type)
+}
+
+// sinksFor returns the sink configuration for the request's queue.
+func (f *LogConfig) sinksFor(req QueueRequest) []LogSinkConfig {
+	if f == nil {
+		return defaultLogSinks(req)
+	}
+	if sinks, ok := f.Queues[req.RequestQueueName]; ok {
+		return sinks
+	}
+	return f.Default
+}
+
+func defaultLogSinks(req QueueRequest) []LogSinkConfig {
+	blob := LogSinkConfig{Type: "blob"}
+	if req.LogAppendBlob {
+		blob.Type = "appendblob"
+	}
+	return []LogSinkConfig{{Type: "console"}, blob}
+}
+
+// newLogSink builds the sink for one job.
+func newLogSink(config LogSinkConfig, req QueueRequest) (LogSink, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case "blob", "appendblob":
+		if req.LogContainerName == "" {
+			return nil, nil
+		}
+		return &BlobLogSink{
+			ConnectionString: req.LogStorageConnectionString,
+			Container:        req.LogContainerName,
+			Append:           config.Type == "appendblob",
+			appendPosition:   -1,
+		}, nil
+	case "console":
+		return &ConsoleLogSink{Out: os.Stdout}, nil
+	case "file":
+		return newFileLogSink(config, req)
+	}
+	connString := config.ConnectionString
+	if connString == "" {
+		connString = utils.GetSQLConnectString()
+	}
+	table := config.Table
+	if table == "" {
+		table = "job_log"
+	}
+	return NewSQLLogSink(connString, table)
+}
+
+// ConsoleLogSink writes records to Out, one whole line at a time, as they
+// are logged.
+type ConsoleLogSink struct {
+	Out io.Writer
+}
+
+func (f *ConsoleLogSink) Unbuffered() {}
+
+func (f *ConsoleLogSink) Write(job *LogJob, records []LogRecord) error {
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+	for _, record := range records {
+		if _, err := io.WriteString(f.Out, record.Line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *ConsoleLogSink) Close(job *LogJob) error {
+	return nil
+}
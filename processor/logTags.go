@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"errors"
+	"main/utils"
+	"strings"
+	"time"
+)
+
+// Blob index tags set on every saved job log.
+const (
+	LogTagMessageID = "messageId"
+	LogTagProcessor = "processor"
+	LogTagStatus    = "status"
+	// LogTagDate is the UTC day the log was saved, as 2006-01-02.
+	LogTagDate = "date"
+)
+
+// Values of the status tag.
+const (
+	LogStatusSucceeded = "succeeded"
+	LogStatusFailed    = "failed"
+)
+
+// saveLogTags tags the log blob so it can be found with FindBlobsByTags.
+func saveLogTags(connString string, container string, blobName string, job *LogJob, now time.Time) error {
+	tags := map[string]string{LogTagDate: now.UTC().Format("2006-01-02")}
+	for k, v := range map[string]string{
+		LogTagMessageID: job.MessageID,
+		LogTagProcessor: job.Processor,
+		LogTagStatus:    job.Status,
+	} {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+	return utils.SetBlobTags(connString, container, blobName, tags)
+}
+
+// LogSearch selects job logs by their tags. Empty fields match anything.
+type LogSearch struct {
+	Container string
+	MessageID string
+	Processor string
+	Status    string
+	// From and To bound the day the log was saved, both inclusive.
+	From time.Time
+	To   time.Time
+}
+
+// tagValueChars are the characters, besides letters and digits, that a
+// blob index tag value may hold. A quote can never match, so values with
+// one are rejected rather than escaped.
+const tagValueChars = " +-./:=_"
+
+func isTagValue(value string) bool {
+	for _, c := range value {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune(tagValueChars, c)) {
+			return false
+		}
+	}
+	return len(value) <= 256
+}
+
+// Where builds the tag query for FindBlobsByTags. It fails for values
+// that no tag can hold.
+func (f LogSearch) Where() (string, error) {
+	var clauses []string
+	var err error
+	add := func(key string, op string, value string) {
+		if !isTagValue(value) && err == nil {
+			err = errors.New("invalid " + strings.Trim(key, `"@`) + " " + value + ": tag values hold only letters, digits and '" + tagValueChars + "'")
+		}
+		clauses = append(clauses, key+" "+op+" '"+value+"'")
+	}
+	if f.Container != "" {
+		add("@container", "=", f.Container)
+	}
+	if f.MessageID != "" {
+		add(`"`+LogTagMessageID+`"`, "=", f.MessageID)
+	}
+	if f.Processor != "" {
+		add(`"`+LogTagProcessor+`"`, "=", f.Processor)
+	}
+	if f.Status != "" {
+		add(`"`+LogTagStatus+`"`, "=", f.Status)
+	}
+	// every log has a date tag, so it also serves as a match-all clause
+	from := "0000-00-00"
+	if !f.From.IsZero() {
+		from = f.From.UTC().Format("2006-01-02")
+	}
+	add(`"`+LogTagDate+`"`, ">=", from)
+	if !f.To.IsZero() {
+		add(`"`+LogTagDate+`"`, "<=", f.To.UTC().Format("2006-01-02"))
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(clauses, " AND "), nil
+}
@@ -0,0 +1,39 @@
+package processor_test
+
+import (
+	"main/processor"
+	"testing"
+	"time"
+)
+
+func TestLogSearchWhere(t *testing.T) {
+	day := time.Date(2026, 3, 1, 23, 30, 0, 0, time.FixedZone("CET", 3600))
+	cases := []struct {
+		name   string
+		search processor.LogSearch
+		where  string
+	}{
+		{"all", processor.LogSearch{}, `"date" >= '0000-00-00'`},
+		{"fields", processor.LogSearch{Container: "job-logs", MessageID: "m-1", Processor: "CurrencyConversionSync", Status: processor.LogStatusFailed},
+			`@container = 'job-logs' AND "messageId" = 'm-1' AND "processor" = 'CurrencyConversionSync' AND "status" = 'failed' AND "date" >= '0000-00-00'`},
+		{"days in utc", processor.LogSearch{From: day, To: day.AddDate(0, 0, 2)}, `"date" >= '2026-03-01' AND "date" <= '2026-03-03'`},
+		{"allowed characters", processor.LogSearch{MessageID: "a b+c-d.e/f:g=h_i"}, `"messageId" = 'a b+c-d.e/f:g=h_i' AND "date" >= '0000-00-00'`},
+	}
+	for _, c := range cases {
+		where, err := c.search.Where()
+		if err != nil || where != c.where {
+			t.Errorf("%s: got %q %v, want %q", c.name, where, err, c.where)
+		}
+	}
+
+	for _, search := range []processor.LogSearch{
+		{MessageID: "x' OR \"status\" = 'failed"},
+		{Processor: "it's"},
+		{Status: "a;b"},
+		{Container: "logs'"},
+	} {
+		if where, err := search.Where(); err == nil {
+			t.Errorf("%+v: accepted as %q", search, where)
+		}
+	}
+}
@@ -1,32 +1,127 @@
 package processor
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"main/utils"
+	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
+// QueueLogger collects the log of one job and writes it to its sinks:
+// unbuffered sinks such as the console as each record is logged, the
+// others from a background goroutine every flush interval, or sooner once
+// enough has been logged. It is safe for concurrent use and touches no
+// process-wide state, so jobs running in parallel keep their logs apart.
+// LogSave must be called once the job ends.
 type QueueLogger struct {
 	queueRequest QueueRequest
-	r            *os.File
-	w            *os.File
-	rescueStdout *os.File
-	tempLog      string
+
+	// lock guards pending, pendingSize, dropped and partial.
+	lock        sync.Mutex
+	pending     []LogRecord
+	pendingSize int
+	// dropped counts records discarded because pending reached maxBuffer.
+	dropped int
+	// partial is an unterminated line written through Writer.
+	partial []byte
+
+	flushInterval time.Duration
+	flushSize     int
+	maxBuffer     int
+	// flushNow asks the flusher for an early flush; done stops it and
+	// stopped is closed once it has returned.
+	flushNow chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	saveOnce sync.Once
+
+	// flushLock serializes writes to the buffered sinks and guards job.
+	flushLock  sync.Mutex
+	sinks      []LogSink
+	unbuffered []LogSink
+
+	// job describes the job; its fields are set before it starts logging.
+	// started expands the time placeholders of its log name.
+	job     LogJob
+	started time.Time
+
+	// level is the least severe level written; format is LogFormatJSON
+	// or LogFormatText.
+	level  LogLevel
+	format string
+	// redactor masks secrets in every record before any sink sees it.
+	redactor *logRedactor
 }
 
+// consoleLock keeps lines of concurrent jobs from interleaving on stdout.
+var consoleLock sync.Mutex
+
+// NewQueueLogger creates the logger of a job, with the sinks JobLogConfig
+// picks for its queue.
 func NewQueueLogger(queueRequest QueueRequest) *QueueLogger {
-	rescueStdout := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
+	level, _ := ParseLogLevel(queueRequest.LogLevel)
+	format := queueRequest.LogFormat
+	if format != LogFormatText {
+		format = LogFormatJSON
+	}
+	f := &QueueLogger{
+		queueRequest: queueRequest,
+		job:          LogJob{Request: queueRequest},
+		started:      time.Now(),
+		redactor:     newLogRedactor(queueRequest, JobLogConfig.redactPatterns()),
+		level:        level,
+		format:       format,
+		flushNow:     make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	f.flushInterval, f.flushSize, f.maxBuffer = JobLogConfig.flushSettings()
 
-	log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	return &QueueLogger{queueRequest: queueRequest, r: r, w: w, rescueStdout: rescueStdout}
+	for _, config := range JobLogConfig.sinksFor(queueRequest) {
+		sink, err := newLogSink(config, queueRequest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "log sink "+config.Type+": "+err.Error())
+			continue
+		}
+		if sink == nil {
+			continue
+		}
+		if _, ok := sink.(UnbufferedLogSink); ok {
+			f.unbuffered = append(f.unbuffered, sink)
+		} else {
+			f.sinks = append(f.sinks, sink)
+		}
+	}
+	go f.flusher()
+	return f
+}
+
+// flusher writes the buffered sinks in the background until LogSave.
+func (f *QueueLogger) flusher() {
+	defer close(f.stopped)
+	ticker := time.NewTicker(f.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+		case <-f.flushNow:
+		}
+		f.lock.Lock()
+		waiting := len(f.pending) > 0 || f.dropped > 0
+		f.lock.Unlock()
+		if waiting {
+			f.flush()
+		}
+	}
 }
 
+// Log writes each line of strInput as an info record. With forceUpload the
+// log is uploaded right away.
 func (f *QueueLogger) Log(strInput string, forceUpload ...bool) {
 
 	upload := false
@@ -36,36 +131,161 @@ func (f *QueueLogger) Log(strInput string, forceUpload ...bool) {
 
 	for _, s := range strings.Split(strInput, "\n") {
 		if s != "" {
-			log.Println(s)
-			fmt.Println("[" + time.Now().UTC().Format("2006-01-01T01:00:00") + "]" + s)
+			f.write(LevelInfo, s, nil)
 		}
 	}
 
 	if upload == true {
+		f.flush()
+	}
+}
 
-		f.w.Close()
-		out, _ := ioutil.ReadAll(f.r)
-		f.tempLog += string(out)
-		f.r, f.w, _ = os.Pipe()
-		os.Stdout = f.w
+// Debug, Info, Warn and Error write a record with the key-value pairs in
+// keyValues, e.g. Info("rates loaded", "count", 170).
+func (f *QueueLogger) Debug(msg string, keyValues ...interface{}) {
+	f.write(LevelDebug, msg, logFields(keyValues))
+}
+
+func (f *QueueLogger) Info(msg string, keyValues ...interface{}) {
+	f.write(LevelInfo, msg, logFields(keyValues))
+}
 
-		container := f.queueRequest.LogContainerName
-		fileName := f.queueRequest.LogFileName
+func (f *QueueLogger) Warn(msg string, keyValues ...interface{}) {
+	f.write(LevelWarn, msg, logFields(keyValues))
+}
 
-		_ = utils.PutBlob(f.queueRequest.LogStorageConnectionString, container, fileName, f.tempLog)
+func (f *QueueLogger) Error(msg string, keyValues ...interface{}) {
+	f.write(LevelError, msg, logFields(keyValues))
+}
+
+// Writer returns an io.Writer for libraries that log through one, such as
+// log.New(logger.Writer(), "", 0). Each line written becomes an info record.
+func (f *QueueLogger) Writer() io.Writer {
+	return queueLoggerWriter{f}
+}
+
+type queueLoggerWriter struct {
+	logger *QueueLogger
+}
+
+func (w queueLoggerWriter) Write(p []byte) (int, error) {
+	f := w.logger
+	f.lock.Lock()
+	data := append(f.partial, p...)
+	i := bytes.LastIndexByte(data, '\n')
+	var lines []byte
+	if i >= 0 {
+		lines, data = data[:i], data[i+1:]
 	}
+	f.partial = append([]byte(nil), data...)
+	f.lock.Unlock()
+
+	for _, line := range strings.Split(string(lines), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			f.write(LevelInfo, line, nil)
+		}
+	}
+	return len(p), nil
 }
 
+// write formats a record with the job's fields first, masks its secrets,
+// hands it to the unbuffered sinks and queues it for the others.
+func (f *QueueLogger) write(level LogLevel, msg string, fields []logField) {
+	if level < f.level {
+		return
+	}
+	var job []logField
+	if f.queueRequest.RequestQueueName != "" {
+		job = append(job, logField{"queue", f.queueRequest.RequestQueueName})
+	}
+	if f.job.MessageID != "" {
+		job = append(job, logField{"messageId", f.job.MessageID}, logField{"attempt", f.job.Attempt})
+	}
+	if f.job.Processor != "" {
+		job = append(job, logField{"processor", f.job.Processor})
+	}
+	now := time.Now()
+	line := formatLogRecord(f.format, now, level, msg, append(job, fields...))
+	record := LogRecord{Time: now, Level: level, Message: f.redactor.redact(msg), Line: f.redactor.redact(line) + "\n"}
+
+	for _, sink := range f.unbuffered {
+		if err := sink.Write(&f.job, []LogRecord{record}); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}
+
+	f.lock.Lock()
+	f.pending = append(f.pending, record)
+	f.pendingSize += len(record.Line)
+	for f.pendingSize > f.maxBuffer && len(f.pending) > 1 {
+		f.pendingSize -= len(f.pending[0].Line)
+		f.pending = f.pending[1:]
+		f.dropped++
+	}
+	full := f.pendingSize >= f.flushSize
+	f.lock.Unlock()
+
+	if full {
+		select {
+		case f.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush writes the records logged since the last flush to the buffered
+// sinks.
+func (f *QueueLogger) flush() {
+	f.flushLock.Lock()
+	defer f.flushLock.Unlock()
+
+	f.lock.Lock()
+	records, dropped := f.pending, f.dropped
+	f.pending, f.pendingSize, f.dropped = nil, 0, 0
+	f.lock.Unlock()
+
+	if dropped > 0 {
+		now := time.Now()
+		msg := "log records dropped while sinks were behind"
+		line := formatLogRecord(f.format, now, LevelWarn, msg, []logField{{"dropped", dropped}}) + "\n"
+		records = append([]LogRecord{{Time: now, Level: LevelWarn, Message: msg, Line: line}}, records...)
+	}
+	if f.job.LogName == "" {
+		f.job.LogName = expandLogName(&f.job, f.started)
+	}
+
+	for _, sink := range f.sinks {
+		if err := sink.Write(&f.job, records); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}
+}
+
+// LogSave stops background flushing, flushes everything logged so far and
+// closes the sinks. Later calls do nothing.
 func (f *QueueLogger) LogSave() {
+	f.saveOnce.Do(f.save)
+}
 
-	f.w.Close()
-	out, _ := ioutil.ReadAll(f.r)
-	os.Stdout = f.rescueStdout
-	log.SetOutput(os.Stdout)
+func (f *QueueLogger) save() {
+	close(f.done)
+	<-f.stopped
 
-	container := f.queueRequest.LogContainerName
-	fileName := f.queueRequest.LogFileName
+	f.lock.Lock()
+	partial := string(f.partial)
+	f.partial = nil
+	f.lock.Unlock()
+	if partial != "" {
+		f.write(LevelInfo, partial, nil)
+	}
 
-	_ = utils.PutBlob(f.queueRequest.LogStorageConnectionString, container, fileName, f.tempLog+string(out))
+	f.flush()
 
+	f.flushLock.Lock()
+	defer f.flushLock.Unlock()
+	for _, sink := range append(f.unbuffered, f.sinks...) {
+		if err := sink.Close(&f.job); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}
 }
@@ -1,14 +1,32 @@
 package processor
 
+import (
+	"errors"
+	"time"
+)
+
 type QueueRequest struct {
 	RequestStorageConnectionString string
 	RequestQueueName               string
 
 	LogStorageConnectionString string
 	LogContainerName           string
-	LogFileName                string
+	// LogFileName names the log blob and may hold placeholders, e.g.
+	// "{date}/{processor}-{messageId}.log"; see expandLogName. Defaults
+	// to DefaultLogFileName.
+	LogFileName string
+	// LogAppendBlob writes the log as an append blob that each flush only
+	// appends to, instead of re-uploading the whole log as a block blob.
+	LogAppendBlob bool
+	// LogLevel is debug, info (the default), warn or error.
+	LogLevel string
+	// LogFormat is json (the default) or text.
+	LogFormat string
 
 	RequestTime string
+	// JobID keys the job's checkpoints. Defaults to the ID of the message
+	// that first carried the job.
+	JobID string
 
 	DBConnectionStrng string
 
@@ -16,3 +34,28 @@ type QueueRequest struct {
 
 	Parameters map[string]string
 }
+
+// Validate reports fields that would make the request fail once it is processed.
+func (f *QueueRequest) Validate() error {
+	if f.KeepLogDays < 0 {
+		return errors.New("KeepLogDays must not be negative")
+	}
+	if f.LogFileName != "" && f.LogContainerName == "" {
+		return errors.New("LogFileName needs a LogContainerName")
+	}
+	if err := validateLogName(f.LogFileName); err != nil {
+		return err
+	}
+	if _, err := ParseLogLevel(f.LogLevel); err != nil {
+		return err
+	}
+	if f.LogFormat != "" && f.LogFormat != LogFormatJSON && f.LogFormat != LogFormatText {
+		return errors.New("LogFormat must be json or text")
+	}
+	if f.RequestTime != "" {
+		if _, err := time.Parse(time.RFC3339, f.RequestTime); err != nil {
+			return errors.New("RequestTime is not RFC 3339: " + f.RequestTime)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"main/utils"
+	"time"
+)
+
+// RetryPolicy is the schedule on which a failed job is re-enqueued. The
+// n-th retry becomes visible Delays[n] after the failure; once every delay
+// has been used the message moves to DeadLetterQueue.
+type RetryPolicy struct {
+	Delays []time.Duration
+	// DeadLetterQueue defaults to the source queue name with a "-poison" suffix.
+	DeadLetterQueue string
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Delays: []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour},
+}
+
+// RetryPolicyProvider is implemented by processors that need a schedule
+// other than DefaultRetryPolicy.
+type RetryPolicyProvider interface {
+	RetryPolicy() RetryPolicy
+}
+
+// RetryPolicies maps a processor type name, e.g. CurrencyConversionSync,
+// to its retry policy, overriding RetryPolicyProvider. The "default" entry
+// applies to processors that have neither. See LoadRetryPolicies.
+var RetryPolicies map[string]RetryPolicy
+
+// retryPolicyConfig is a RetryPolicy in a configuration file.
+type retryPolicyConfig struct {
+	// Delays are durations such as "1m" or "2h".
+	Delays          []string `json:"delays"`
+	DeadLetterQueue string   `json:"deadLetterQueue,omitempty"`
+}
+
+// LoadRetryPolicies reads retry policies by processor type name:
+//
+//	{"CurrencyConversionSync": {"delays": ["1m", "5m", "30m", "2h"]},
+//	 "default": {"delays": ["5m"], "deadLetterQueue": "failed-jobs"}}
+func LoadRetryPolicies(path string) (map[string]RetryPolicy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs map[string]retryPolicyConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	policies := make(map[string]RetryPolicy, len(configs))
+	for name, config := range configs {
+		policy := RetryPolicy{DeadLetterQueue: config.DeadLetterQueue}
+		for _, s := range config.Delays {
+			delay, err := time.ParseDuration(s)
+			if err != nil || delay < 0 {
+				return nil, errors.New(path + ": " + name + ": invalid delay " + s)
+			}
+			policy.Delays = append(policy.Delays, delay)
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+func RetryPolicyFor(overrideProcess OverrideProcess) RetryPolicy {
+	if policy, ok := RetryPolicies[processorName(overrideProcess)]; ok {
+		return policy
+	}
+	if provider, ok := overrideProcess.(RetryPolicyProvider); ok {
+		return provider.RetryPolicy()
+	}
+	if policy, ok := RetryPolicies["default"]; ok {
+		return policy
+	}
+	return DefaultRetryPolicy
+}
+
+// Retry re-enqueues a failed message on the policy's schedule, or moves it
+// to the dead-letter queue once the schedule is exhausted. The payload is
+// sealed again as the original was, with the compression and encryption
+// its envelope records, and otherwise with the options in params, so a
+// retry that outgrows the queue can go to a claim-check blob. A message
+// that already has a claim-check blob keeps it. The caller deletes the
+// original only once Retry succeeds.
+func Retry(connString string, queueName string, msg *utils.QueueMessage, policy RetryPolicy, params ...utils.MessageOptions) error {
+	var opts utils.MessageOptions
+	if len(params) > 0 {
+		opts = params[0]
+	}
+	opts.VisibilityTimeout = 0
+	opts.JobID = msg.JobID()
+
+	attempt := msg.Attempt()
+	if attempt >= len(policy.Delays) {
+		deadLetterQueue := policy.DeadLetterQueue
+		if deadLetterQueue == "" {
+			deadLetterQueue = utils.DeadLetterQueue(queueName)
+		}
+		opts.Attempt = attempt
+		return postRetry(connString, deadLetterQueue, msg, opts)
+	}
+
+	opts.Attempt = attempt + 1
+	opts.VisibilityTimeout = policy.Delays[attempt]
+	return postRetry(connString, queueName, msg, opts)
+}
+
+func postRetry(connString string, queueName string, msg *utils.QueueMessage, opts utils.MessageOptions) error {
+	if env := msg.Envelope; env != nil && env.ClaimCheck != nil {
+		copied := *env
+		copied.Attempt, copied.JobID = opts.Attempt, opts.JobID
+		_, err := utils.OpenQueue(connString, queueName).Send(copied.String(), opts.VisibilityTimeout)
+		return err
+	}
+	if env := msg.Envelope; env != nil {
+		opts.Codec = env.Codec
+		opts.Encrypt = env.KeyID != ""
+	}
+	_, err := utils.SendMessage(connString, queueName, msg.Body, opts)
+	return err
+}
+
+// Replay moves up to max messages from one queue to another, typically from
+// a dead-letter queue back to its source, with the attempt counter reset.
+// It returns how many messages were moved.
+func Replay(connString string, fromQueue string, toQueue string, max int) (int, error) {
+	from := utils.OpenQueue(connString, fromQueue)
+	to := utils.OpenQueue(connString, toQueue)
+
+	moved := 0
+	for moved < max {
+		count := max - moved
+		if count > 32 {
+			count = 32
+		}
+		messages, err := from.Receive(count, time.Minute)
+		if err != nil {
+			return moved, err
+		}
+		if len(messages) == 0 {
+			return moved, nil
+		}
+
+		for i := range messages {
+			msg := &messages[i]
+			messageText := msg.MessageText
+			if msg.Envelope != nil {
+				env := *msg.Envelope
+				env.Attempt = 0
+				messageText = env.String()
+			}
+			if _, err := to.Send(messageText, 0); err != nil {
+				return moved, err
+			}
+			if err := from.Delete(msg.MessageId, msg.PopReceipt); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"errors"
+	"main/utils"
+	"strings"
+	"sync"
+)
+
+// SQLLogSink inserts each record as a row of a MySQL table, created on
+// first use:
+//
+//	CREATE TABLE job_log (
+//	    id         BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+//	    message_id VARCHAR(255) NOT NULL,
+//	    queue      VARCHAR(255) NOT NULL,
+//	    processor  VARCHAR(255) NOT NULL,
+//	    attempt    INT          NOT NULL,
+//	    level      VARCHAR(8)   NOT NULL,
+//	    logged_at  DATETIME(6)  NOT NULL,
+//	    message    TEXT         NOT NULL,
+//	    record     TEXT         NOT NULL,
+//	    KEY (message_id)
+//	)
+type SQLLogSink struct {
+	connString string
+	table      string
+}
+
+// sqlLogBatchSize bounds the rows of one INSERT, keeping it well below
+// MySQL's limit of 65,535 placeholders per statement.
+const sqlLogBatchSize = 1000
+
+var (
+	sqlLogTablesLock sync.Mutex
+	// sqlLogTables are the tables known to exist.
+	sqlLogTables = make(map[string]bool)
+)
+
+func NewSQLLogSink(connString string, table string) (*SQLLogSink, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, errors.New("invalid log table name " + table)
+	}
+	return &SQLLogSink{connString: connString, table: table}, nil
+}
+
+func (f *SQLLogSink) Write(job *LogJob, records []LogRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := f.createTable(); err != nil {
+		return err
+	}
+
+	for len(records) > 0 {
+		batch := records
+		if len(batch) > sqlLogBatchSize {
+			batch = batch[:sqlLogBatchSize]
+		}
+		records = records[len(batch):]
+
+		rows := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, 8*len(batch))
+		for _, record := range batch {
+			rows = append(rows, "(?, ?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, job.MessageID, job.Request.RequestQueueName, job.Processor, job.Attempt,
+				record.Level.String(), record.Time.UTC(), record.Message, strings.TrimSuffix(record.Line, "\n"))
+		}
+		_, _, err := utils.SQLExec(f.connString, false,
+			"INSERT INTO "+f.table+" (message_id, queue, processor, attempt, level, logged_at, message, record) VALUES "+
+				strings.Join(rows, ", "), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *SQLLogSink) Close(job *LogJob) error {
+	return nil
+}
+
+func (f *SQLLogSink) createTable() error {
+	sqlLogTablesLock.Lock()
+	defer sqlLogTablesLock.Unlock()
+	key := f.connString + "\x00" + f.table
+	if sqlLogTables[key] {
+		return nil
+	}
+	_, _, err := utils.SQLExec(f.connString, false, "CREATE TABLE IF NOT EXISTS "+f.table+" ("+
+		"id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY, "+
+		"message_id VARCHAR(255) NOT NULL, "+
+		"queue VARCHAR(255) NOT NULL, "+
+		"processor VARCHAR(255) NOT NULL, "+
+		"attempt INT NOT NULL, "+
+		"level VARCHAR(8) NOT NULL, "+
+		"logged_at DATETIME(6) NOT NULL, "+
+		"message TEXT NOT NULL, "+
+		"record TEXT NOT NULL, "+
+		"KEY (message_id))")
+	sqlLogTables[key] = err == nil
+	return err
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// cronBits sets the bit of every value.
+func cronBits(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func cronRange(lo int, hi int, step int) []int {
+	var values []int
+	for v := lo; v <= hi; v += step {
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestParseCron(t *testing.T) {
+	cases := []struct {
+		expr   string
+		minute uint64
+		hour   uint64
+		dom    uint64
+		month  uint64
+		dow    uint64
+	}{
+		// a "*" day of week holds both Sundays, 0 and 7
+		{"5 * * * *", cronBits(5), cronBits(cronRange(0, 23, 1)...), cronBits(cronRange(1, 31, 1)...), cronBits(cronRange(1, 12, 1)...), cronBits(cronRange(0, 7, 1)...)},
+		{"0 8-18 1-15 6-8 1-5", cronBits(0), cronBits(cronRange(8, 18, 1)...), cronBits(cronRange(1, 15, 1)...), cronBits(6, 7, 8), cronBits(1, 2, 3, 4, 5)},
+		{"*/15 */6 */10 */3 */2", cronBits(0, 15, 30, 45), cronBits(0, 6, 12, 18), cronBits(1, 11, 21, 31), cronBits(1, 4, 7, 10), cronBits(0, 2, 4, 6)},
+		{"10-40/10 5/8 * * *", cronBits(10, 20, 30, 40), cronBits(5, 13, 21), cronBits(cronRange(1, 31, 1)...), cronBits(cronRange(1, 12, 1)...), cronBits(cronRange(0, 7, 1)...)},
+		{"0,30 1,13 1,15,31 1,7 0,6", cronBits(0, 30), cronBits(1, 13), cronBits(1, 15, 31), cronBits(1, 7), cronBits(0, 6)},
+		{"1-3,10,20-26/3 0 * * *", cronBits(1, 2, 3, 10, 20, 23, 26), cronBits(0), cronBits(cronRange(1, 31, 1)...), cronBits(cronRange(1, 12, 1)...), cronBits(cronRange(0, 7, 1)...)},
+		// 7 and 0 are both Sunday
+		{"0 0 * * 7", cronBits(0), cronBits(0), cronBits(cronRange(1, 31, 1)...), cronBits(cronRange(1, 12, 1)...), cronBits(0, 7)},
+		{"  @weekly ", cronBits(0), cronBits(0), cronBits(cronRange(1, 31, 1)...), cronBits(cronRange(1, 12, 1)...), cronBits(0)},
+		{"@yearly", cronBits(0), cronBits(0), cronBits(1), cronBits(1), cronBits(cronRange(0, 7, 1)...)},
+	}
+	for _, c := range cases {
+		s, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		got := [5]uint64{s.minute, s.hour, s.dom, s.month, s.dow}
+		want := [5]uint64{c.minute, c.hour, c.dom, c.month, c.dow}
+		if got != want {
+			t.Errorf("%q: fields %b, want %b", c.expr, got, want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every 5m",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"-1 * * * *",
+		"5-1 * * * *",
+		"1-2-3 * * * *",
+		"1-x * * * *",
+		"a * * * *",
+		"*/0 * * * *",
+		"*/-5 * * * *",
+		"*/x * * * *",
+		"1,,2 * * * *",
+		"JAN * * * *",
+	} {
+		if s, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: parsed as %+v", expr, s)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	cases := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"strictly after", "* * * * *", at("2026-10-19 10:00:00"), at("2026-10-19 10:01:00")},
+		{"seconds are dropped", "* * * * *", at("2026-10-19 10:00:59"), at("2026-10-19 10:01:00")},
+		{"step", "*/15 * * * *", at("2026-10-19 10:14:00"), at("2026-10-19 10:15:00")},
+		{"step into next hour", "*/15 * * * *", at("2026-10-19 10:45:00"), at("2026-10-19 11:00:00")},
+		{"next day", "30 2 * * *", at("2026-10-19 23:00:00"), at("2026-10-20 02:30:00")},
+		// Friday evening to Monday morning
+		{"weekdays", "0 8-18 * * 1-5", at("2026-10-16 18:30:00"), at("2026-10-19 08:00:00")},
+		{"month end", "0 0 1 * *", at("2026-01-31 12:00:00"), at("2026-02-01 00:00:00")},
+		{"year end", "0 0 1 1 *", at("2026-06-01 00:00:00"), at("2027-01-01 00:00:00")},
+		{"short months skipped", "0 0 31 * *", at("2026-04-01 00:00:00"), at("2026-05-31 00:00:00")},
+		{"leap day", "0 0 29 2 *", at("2026-03-01 00:00:00"), at("2028-02-29 00:00:00")},
+		{"never", "0 0 30 2 *", at("2026-01-01 00:00:00"), time.Time{}},
+		{"sunday as 7", "0 0 * * 7", at("2026-10-19 00:00:00"), at("2026-10-25 00:00:00")},
+		// with both day fields restricted either one matches
+		{"day of week or month, friday first", "0 0 13 * 5", at("2026-10-01 00:00:00"), at("2026-10-02 00:00:00")},
+		{"day of week or month, 13th first", "0 0 13 * 5", at("2026-10-10 00:00:00"), at("2026-10-13 00:00:00")},
+		// with one of them "*" only the other one counts
+		{"day of week only", "0 0 * * 5", at("2026-10-10 00:00:00"), at("2026-10-16 00:00:00")},
+		{"day of month only", "0 0 13 * *", at("2026-10-14 00:00:00"), at("2026-11-13 00:00:00")},
+		{"day of month and month", "0 0 13 1 *", at("2026-10-14 00:00:00"), at("2027-01-13 00:00:00")},
+		{"evaluated in utc", "0 9 * * *", time.Date(2026, 10, 19, 10, 30, 0, 0, time.FixedZone("CEST", 2*3600)), at("2026-10-19 09:00:00")},
+	}
+	for _, c := range cases {
+		s, err := ParseCron(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := s.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("%s: %q after %s = %s, want %s", c.name, c.expr, c.from, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"main/processor"
+	"main/utils"
+	"os"
+	"time"
+)
+
+// defaultMaxCatchUp bounds how many missed runs of one entry are enqueued
+// after downtime.
+const defaultMaxCatchUp = 100
+
+// An entry whose post failed is retried after firstRetryDelay, doubling up
+// to RetryInterval. Run never waits less than minWait between passes.
+const (
+	firstRetryDelay      = 5 * time.Second
+	defaultRetryInterval = 5 * time.Minute
+	minWait              = time.Second
+)
+
+// Entry enqueues Template to QueueName every time Schedule fires.
+type Entry struct {
+	Name     string
+	Schedule string
+	// ConnectionString defaults to the scheduler's connection string.
+	ConnectionString string
+	QueueName        string
+	Options          utils.MessageOptions
+	Template         processor.QueueRequest
+	// MaxCatchUp is the most missed runs enqueued at once; older ones are skipped.
+	MaxCatchUp int
+
+	cron *CronSchedule
+}
+
+// Scheduler posts QueueRequests on cron schedules. The time of each
+// entry's last run is kept in StatePath, or in the blob of Lock, so runs
+// missed while the scheduler was down are enqueued when it comes back.
+type Scheduler struct {
+	ConnectionString string
+	StatePath        string
+	Entries          []*Entry
+	// Lock, when set, is the leader lock the scheduler runs under. Its
+	// blob holds the state instead of StatePath, so that every leader
+	// continues from the runs its predecessors posted.
+	Lock *utils.BlobLock `json:"-"`
+	// RetryInterval is the longest wait before a failed post is retried,
+	// e.g. "5m", the default.
+	RetryInterval string
+
+	state         map[string]time.Time
+	retryInterval time.Duration
+	// failures counts the consecutive failed posts of each entry and
+	// retryAt holds when it is tried again.
+	failures map[string]int
+	retryAt  map[string]time.Time
+}
+
+// Load reads a scheduler configuration file:
+//
+//	{"StatePath": "schedule.state.json",
+//	 "Entries": [{"Name": "currency-daily", "Schedule": "0 1 * * *", "QueueName": "demo1",
+//	              "Template": {"Parameters": {"date": "{date-1}"}}}]}
+func Load(path string, connString string) (*Scheduler, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scheduler{}
+	if err := json.Unmarshal(content, s); err != nil {
+		return nil, err
+	}
+	if s.ConnectionString == "" {
+		s.ConnectionString = connString
+	}
+	return s, s.init()
+}
+
+func (f *Scheduler) init() error {
+	f.retryInterval = defaultRetryInterval
+	if f.RetryInterval != "" {
+		d, err := time.ParseDuration(f.RetryInterval)
+		if err != nil || d <= 0 {
+			return errors.New("invalid RetryInterval: " + f.RetryInterval)
+		}
+		f.retryInterval = d
+	}
+	f.failures = make(map[string]int)
+	f.retryAt = make(map[string]time.Time)
+
+	names := make(map[string]bool)
+	for _, entry := range f.Entries {
+		if entry.Name == "" || names[entry.Name] {
+			return errors.New("schedule entries need unique names: \"" + entry.Name + "\"")
+		}
+		names[entry.Name] = true
+
+		cron, err := ParseCron(entry.Schedule)
+		if err != nil {
+			return errors.New(entry.Name + ": " + err.Error())
+		}
+		entry.cron = cron
+		if entry.ConnectionString == "" {
+			entry.ConnectionString = f.ConnectionString
+		}
+		if entry.MaxCatchUp <= 0 {
+			entry.MaxCatchUp = defaultMaxCatchUp
+		}
+	}
+	return f.loadState()
+}
+
+// Run enqueues due entries until ctx is cancelled. The state is reloaded
+// first, as another instance may have run the schedule in the meantime.
+func (f *Scheduler) Run(ctx context.Context) error {
+	if err := f.loadState(); err != nil {
+		return err
+	}
+	f.failures = make(map[string]int)
+	f.retryAt = make(map[string]time.Time)
+	for {
+		now := time.Now().UTC()
+		next := now.Add(time.Hour)
+		for _, entry := range f.Entries {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			n := f.retryAt[entry.Name]
+			if !n.After(now) {
+				n = f.runEntry(entry, now)
+			}
+			if !n.IsZero() && n.Before(next) {
+				next = n
+			}
+		}
+		if floor := now.Add(minWait); next.Before(floor) {
+			next = floor
+		}
+		if err := f.saveState(); err != nil {
+			fmt.Println("scheduler state save failed: " + err.Error())
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// runEntry enqueues the due runs of entry and returns when it is due next.
+// After a failed post that is when it is retried, with a backoff.
+func (f *Scheduler) runEntry(entry *Entry, now time.Time) time.Time {
+	if err := f.runDue(entry, now); err != nil {
+		fmt.Println("scheduler " + entry.Name + ": " + err.Error())
+		delay := firstRetryDelay << uint(f.failures[entry.Name])
+		if delay <= 0 || delay > f.retryInterval {
+			delay = f.retryInterval
+		} else {
+			f.failures[entry.Name]++
+		}
+		f.retryAt[entry.Name] = now.Add(delay)
+		return f.retryAt[entry.Name]
+	}
+	delete(f.failures, entry.Name)
+	delete(f.retryAt, entry.Name)
+	return entry.cron.Next(f.state[entry.Name])
+}
+
+// runDue enqueues every run of entry that fell due up to now, saving the
+// state after each. A failed post stops the loop so the run is retried
+// later.
+func (f *Scheduler) runDue(entry *Entry, now time.Time) error {
+	last, ok := f.state[entry.Name]
+	if !ok {
+		f.state[entry.Name] = now
+		return nil
+	}
+
+	count := 0
+	for t := entry.cron.Next(last); !t.IsZero() && !t.After(now); t = entry.cron.Next(t) {
+		if count == entry.MaxCatchUp {
+			fmt.Println("scheduler " + entry.Name + ": skipping missed runs before " + now.Format(time.RFC3339))
+			f.state[entry.Name] = now
+			return nil
+		}
+		if err := f.Enqueue(entry, t); err != nil {
+			return err
+		}
+		f.state[entry.Name] = t
+		if err := f.saveState(); err != nil {
+			return errors.New("state save failed: " + err.Error())
+		}
+		count++
+	}
+	return nil
+}
+
+// Enqueue posts the entry's template expanded for runTime.
+func (f *Scheduler) Enqueue(entry *Entry, runTime time.Time) error {
+	req, err := ExpandTemplate(entry.Template, runTime)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if _, err := utils.SendMessage(entry.ConnectionString, entry.QueueName, string(data), entry.Options); err != nil {
+		return err
+	}
+	fmt.Println("scheduler " + entry.Name + ": enqueued run " + runTime.Format(time.RFC3339))
+	return nil
+}
+
+func (f *Scheduler) loadState() error {
+	f.state = make(map[string]time.Time)
+	var content []byte
+	var err error
+	switch {
+	case f.Lock != nil:
+		content, err = f.Lock.ReadContent()
+		if utils.IsNotFound(err) {
+			return nil
+		}
+	case f.StatePath != "":
+		content, err = ioutil.ReadFile(f.StatePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+	}
+	if err != nil || len(content) == 0 {
+		return err
+	}
+	return json.Unmarshal(content, &f.state)
+}
+
+func (f *Scheduler) saveState() error {
+	if f.Lock == nil && f.StatePath == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(f.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if f.Lock != nil {
+		return f.Lock.WriteContent(content)
+	}
+	tmp := f.StatePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.StatePath)
+}
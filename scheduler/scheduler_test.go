@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"context"
+	"main/utils"
+	"main/utils/storagetest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(t *testing.T, connString string) *Scheduler {
+	t.Helper()
+	s := &Scheduler{
+		ConnectionString: connString,
+		RetryInterval:    "1m",
+		Entries:          []*Entry{{Name: "minutely", Schedule: "* * * * *", QueueName: "scheduled"}},
+	}
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	s := newTestScheduler(t, "memory:catch-up")
+	s.StatePath = filepath.Join(t.TempDir(), "state.json")
+	now := time.Now().UTC().Truncate(time.Minute)
+
+	// the state is saved as each missed run is posted, not only when the
+	// pass ends, so a crash midway cannot post a run twice
+	s.state["minutely"] = now.Add(-3 * time.Minute)
+	if err := s.runDue(s.Entries[0], now); err != nil {
+		t.Fatal(err)
+	}
+	saved := newTestScheduler(t, "memory:catch-up")
+	saved.StatePath = s.StatePath
+	if err := saved.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if !saved.state["minutely"].Equal(now) {
+		t.Fatalf("saved state %v", saved.state["minutely"])
+	}
+	if count, err := utils.OpenQueue("memory:catch-up", "scheduled").Count(); err != nil || count != 3 {
+		t.Fatalf("enqueued %d runs, %v", count, err)
+	}
+}
+
+func TestSchedulerSharedState(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+	if _, err := utils.CreateContainerIfNotExists(connString, "locks"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+
+	// the first leader catches up on three missed runs
+	first := newTestScheduler(t, connString)
+	first.Lock = utils.NewBlobLock(connString, "locks", "scheduler")
+	if _, err := first.Lock.TryLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	first.state["minutely"] = now.Add(-3 * time.Minute)
+	if err := first.runDue(first.Entries[0], now); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next leader continues from the state its predecessor saved
+	second := newTestScheduler(t, connString)
+	second.Lock = utils.NewBlobLock(connString, "locks", "scheduler")
+	if _, err := second.Lock.TryLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Lock.Unlock()
+	if err := second.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.runDue(second.Entries[0], now); err != nil {
+		t.Fatal(err)
+	}
+	if !second.state["minutely"].Equal(now) {
+		t.Fatalf("state %v", second.state["minutely"])
+	}
+	if count, err := utils.GetQueueMessageCount(connString, "scheduled"); err != nil || count != 3 {
+		t.Fatalf("enqueued %d runs, %v", count, err)
+	}
+}
+
+func TestSchedulerRetryBackoff(t *testing.T) {
+	storage := storagetest.NewServer()
+	connString := storage.ConnectionString()
+	storage.Close()
+
+	s := newTestScheduler(t, connString)
+	now := time.Now().UTC().Truncate(time.Minute)
+	s.state["minutely"] = now.Add(-time.Minute)
+	for _, want := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		if next := s.runEntry(s.Entries[0], now); !next.Equal(now.Add(want)) {
+			t.Fatalf("retry after %v, want %v", next.Sub(now), want)
+		}
+	}
+}
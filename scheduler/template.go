@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"main/processor"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// placeholderPattern matches {date}, {date-1}, {date+7:20060102}, {time} and
+// {time:15:04}. The offset is in days; the optional layout uses Go's
+// reference time.
+var placeholderPattern = regexp.MustCompile(`\{(date|time)([+-]\d+)?(?::([^}]+))?\}`)
+
+// ExpandPlaceholders replaces date placeholders in s relative to the
+// scheduled run time.
+func ExpandPlaceholders(s string, runTime time.Time) string {
+	return placeholderPattern.ReplaceAllStringFunc(s, func(match string) string {
+		groups := placeholderPattern.FindStringSubmatch(match)
+		t := runTime.UTC()
+		if groups[2] != "" {
+			days, _ := strconv.Atoi(groups[2])
+			t = t.AddDate(0, 0, days)
+		}
+		layout := groups[3]
+		if layout == "" {
+			layout = "2006-01-02"
+			if groups[1] == "time" {
+				layout = time.RFC3339
+			}
+		}
+		return t.Format(layout)
+	})
+}
+
+// ExpandTemplate returns a copy of template with placeholders expanded in
+// every string field and parameter except LogFileName, whose placeholders
+// the job's logger expands. RequestTime defaults to the run time.
+func ExpandTemplate(template processor.QueueRequest, runTime time.Time) (processor.QueueRequest, error) {
+	var req processor.QueueRequest
+	logFileName := template.LogFileName
+	template.LogFileName = ""
+
+	s, err := json.Marshal(template)
+	if err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal([]byte(ExpandPlaceholders(string(s), runTime)), &req); err != nil {
+		return req, err
+	}
+	req.LogFileName = logFileName
+	if req.RequestTime == "" {
+		req.RequestTime = runTime.UTC().Format(time.RFC3339)
+	}
+	return req, nil
+}
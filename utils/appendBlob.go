@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// MaxAppendBlockSize is the largest block a single Append Block call accepts.
+const MaxAppendBlockSize = 4 * 1024 * 1024
+
+// CreateAppendBlob creates an empty append blob, with the metadata in
+// params if given. Unless overwrite is set, an existing blob is left alone
+// and a 409 StorageError is returned.
+func CreateAppendBlob(connString string, container string, blobName string, overwrite bool, params ...map[string]string) error {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return err
+	}
+
+	header := map[string][]string{"x-ms-blob-type": {"AppendBlob"}}
+	if !overwrite {
+		header[headerIfNoneMatch] = []string{"*"}
+	}
+	if len(params) > 0 {
+		metadataHeader(params[0], header)
+	}
+	resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(container+"/"+blobName), nil, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return newStorageError(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// AppendBlob appends data to an append blob in blocks of at most
+// MaxAppendBlockSize and returns the blob's length afterwards. When
+// appendPosition is not negative each block is only appended if the blob
+// is exactly as long as expected, so a concurrent writer causes a 412
+// StorageError instead of interleaved output.
+func AppendBlob(connString string, container string, blobName string, data []byte, appendPosition int64) (int64, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return appendPosition, err
+	}
+
+	length := appendPosition
+	for len(data) > 0 {
+		block := data
+		if len(block) > MaxAppendBlockSize {
+			block = block[:MaxAppendBlockSize]
+		}
+
+		header := make(map[string][]string)
+		if appendPosition >= 0 {
+			header["x-ms-blob-condition-appendpos"] = []string{strconv.FormatInt(length, 10)}
+		}
+		resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(container+"/"+blobName, "comp=appendblock"), block, header)
+		if err != nil {
+			return length, err
+		}
+		if resp.StatusCode != http.StatusCreated {
+			return length, newStorageError(resp)
+		}
+		resp.Body.Close()
+
+		offset, err := strconv.ParseInt(resp.Header.Get("x-ms-blob-append-offset"), 10, 64)
+		if err != nil {
+			return length, err
+		}
+		length = offset + int64(len(block))
+		data = data[len(block):]
+	}
+	return length, nil
+}
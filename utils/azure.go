@@ -9,7 +9,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -38,27 +37,60 @@ const (
 
 // NewSharedKeyCredential creates an immutable SharedKeyCredential containing the
 // storage account's name and either its primary or secondary key.
+// QueueEndpoint and BlobEndpoint settings in connString override the
+// default service endpoints, e.g. to point at an emulator.
 func NewSharedKeyCredential(connString string) (*SharedKeyCredential, error) {
 
-	parts := strings.Split(connString, ";")
-	accountName := strings.Split(parts[1], "=")[1]
-
-	match1 := regexp.MustCompile("(.?AccountKey)([^;])")
-	accountKey := match1.Split(parts[2], -1)[1]
+	settings := make(map[string]string)
+	for _, part := range strings.Split(connString, ";") {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			settings[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	accountName := settings["AccountName"]
+	accountKey := settings["AccountKey"]
+	if accountName == "" || accountKey == "" {
+		return &SharedKeyCredential{}, errors.New("invalid storage connection string")
+	}
 
 	bytes, err := base64.StdEncoding.DecodeString(accountKey)
 	if err != nil {
 		return &SharedKeyCredential{}, err
 	}
-	return &SharedKeyCredential{accountName: accountName, accountKey: bytes}, nil
+
+	protocol := settings["DefaultEndpointsProtocol"]
+	if protocol == "" {
+		protocol = "https"
+	}
+	suffix := settings["EndpointSuffix"]
+	if suffix == "" {
+		suffix = "core.windows.net"
+	}
+	queueEndpoint := settings["QueueEndpoint"]
+	if queueEndpoint == "" {
+		queueEndpoint = protocol + "://" + accountName + ".queue." + suffix
+	}
+	blobEndpoint := settings["BlobEndpoint"]
+	if blobEndpoint == "" {
+		blobEndpoint = protocol + "://" + accountName + ".blob." + suffix
+	}
+
+	return &SharedKeyCredential{
+		accountName:   accountName,
+		accountKey:    bytes,
+		queueEndpoint: strings.TrimSuffix(queueEndpoint, "/"),
+		blobEndpoint:  strings.TrimSuffix(blobEndpoint, "/"),
+	}, nil
 }
 
 // SharedKeyCredential contains an account's name and its primary or secondary key.
 // It is immutable making it shareable and goroutine-safe.
 type SharedKeyCredential struct {
 	// Only the NewSharedKeyCredential method should set these; all other methods should treat them as read-only
-	accountName string
-	accountKey  []byte
+	accountName   string
+	accountKey    []byte
+	queueEndpoint string
+	blobEndpoint  string
 }
 
 // AccountName returns the Storage account's name.
@@ -66,6 +98,32 @@ func (f SharedKeyCredential) AccountName() string {
 	return f.accountName
 }
 
+// QueueURL returns the URL of path, e.g. "myqueue/messages", on the queue
+// service, with the encoded query in params.
+func (f SharedKeyCredential) QueueURL(path string, params ...string) string {
+	return serviceURL(f.queueEndpoint, path, params)
+}
+
+// BlobURL returns the URL of path, e.g. "container/blob", on the blob
+// service, with the encoded query in params.
+func (f SharedKeyCredential) BlobURL(path string, params ...string) string {
+	return serviceURL(f.blobEndpoint, path, params)
+}
+
+// serviceURL escapes each segment of path, so that names containing
+// characters such as '?', '#' or '%' reach the service unchanged.
+func serviceURL(endpoint string, path string, query []string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	u := endpoint + "/" + strings.Join(segments, "/")
+	if len(query) > 0 && query[0] != "" {
+		u += "?" + query[0]
+	}
+	return u
+}
+
 func (f SharedKeyCredential) getAccountKey() []byte {
 	return f.accountKey
 }
@@ -97,7 +155,7 @@ func (f *SharedKeyCredential) HttpGetRequest(httpGet *HttpGet) error {
 	httpGet.Request.Header[headerAuthorization] = []string{authHeader}
 
 	httpGet.Response, httpGet.Error = httpClient.Do(httpGet.Request)
-	if httpGet.Error != nil && httpGet.Response != nil {
+	if httpGet.Error != nil {
 		return httpGet.Error
 	}
 	defer httpGet.Response.Body.Close()
@@ -137,7 +195,7 @@ func (f *SharedKeyCredential) HttpPostRequest(httpPost *HttpPost) error {
 	httpPost.Request.Header[headerAuthorization] = []string{authHeader}
 
 	httpPost.Response, httpPost.Error = httpClient.Do(httpPost.Request)
-	if httpPost.Error != nil && httpPost.Response != nil {
+	if httpPost.Error != nil {
 		return httpPost.Error
 	}
 	defer httpPost.Response.Body.Close()
@@ -188,7 +246,7 @@ func (f *SharedKeyCredential) HttpPutRequest(httpPost *HttpPost, params ...map[s
 	httpPost.Request.Header[headerAuthorization] = []string{authHeader}
 
 	httpPost.Response, httpPost.Error = httpClient.Do(httpPost.Request)
-	if httpPost.Error != nil && httpPost.Response != nil {
+	if httpPost.Error != nil {
 		return httpPost.Error
 	}
 	defer httpPost.Response.Body.Close()
@@ -228,7 +286,7 @@ func (f *SharedKeyCredential) HttpDeleteRequest(httpGet *HttpGet) error {
 	httpGet.Request.Header[headerAuthorization] = []string{authHeader}
 
 	httpGet.Response, httpGet.Error = httpClient.Do(httpGet.Request)
-	if httpGet.Error != nil && httpGet.Response != nil {
+	if httpGet.Error != nil {
 		return httpGet.Error
 	}
 	defer httpGet.Response.Body.Close()
@@ -241,6 +299,34 @@ func (f *SharedKeyCredential) HttpDeleteRequest(httpGet *HttpGet) error {
 
 }
 
+// HttpStreamRequest sends a signed request with any method and headers and
+// returns the response unread, so large bodies can be streamed. The caller
+// must close the response body.
+func (f *SharedKeyCredential) HttpStreamRequest(method string, URI string, body []byte, header map[string][]string) (*http.Response, error) {
+	httpClient := &http.Client{}
+
+	request, err := http.NewRequest(method, URI, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header[headerXmsDate] = []string{time.Now().UTC().Format(http.TimeFormat)}
+	request.Header[headerXmsVersion] = []string{"2020-04-08"}
+	request.Header[headerContentLength] = []string{strconv.Itoa(len(body))}
+	for k, v := range header {
+		request.Header[http.CanonicalHeaderKey(k)] = v
+	}
+
+	stringToSign, err := f.buildStringToSign(request)
+	if err != nil {
+		return nil, err
+	}
+	signature := f.ComputeHMACSHA256(stringToSign)
+	authHeader := strings.Join([]string{"SharedKey ", f.accountName, ":", signature}, "")
+	request.Header[headerAuthorization] = []string{authHeader}
+
+	return httpClient.Do(request)
+}
+
 func (f SharedKeyCredential) ComputeHMACSHA256(message string) (base64String string) {
 	h := hmac.New(sha256.New, f.accountKey)
 	h.Write([]byte(message))
@@ -316,6 +402,12 @@ func buildCanonicalizedHeader(headers http.Header) string {
 	return ch.String()
 }
 
+// StringToSign returns the string a SharedKey signature of request is
+// computed from, for servers such as storagetest that check signatures.
+func (f *SharedKeyCredential) StringToSign(request *http.Request) (string, error) {
+	return f.buildStringToSign(request)
+}
+
 func (f *SharedKeyCredential) buildStringToSign(request *http.Request) (string, error) {
 	// https://docs.microsoft.com/en-us/rest/api/storageservices/authentication-for-the-azure-storage-services
 	headers := request.Header
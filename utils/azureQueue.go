@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/stretchr/objx"
+)
+
+// AzureQueue is the Queue backend for an Azure Storage queue.
+type AzureQueue struct {
+	connString string
+	queueName  string
+}
+
+func NewAzureQueue(connString string, queueName string) *AzureQueue {
+	return &AzureQueue{connString: connString, queueName: queueName}
+}
+
+func (f *AzureQueue) Send(messageText string, visibilityTimeout time.Duration) (string, error) {
+	post := PostQueue(f.connString, f.queueName, messageText, MessageOptions{VisibilityTimeout: visibilityTimeout})
+	if post.Error != nil {
+		return "", post.Error
+	}
+	if post.StatusCode != 201 {
+		return "", errors.New("send to " + f.queueName + " failed: " + strconv.Itoa(post.StatusCode) + " " + string(post.ResponseBody))
+	}
+	jobject, _ := objx.FromJSON(string(post.ResponseBody))
+	return jobject.Get("QueueMessagesList.QueueMessage.MessageId").Str(), nil
+}
+
+func (f *AzureQueue) Receive(count int, visibilityTimeout time.Duration) ([]QueueMessage, error) {
+	return GetQueueMessages(f.connString, f.queueName, count, int(visibilityTimeout/time.Second))
+}
+
+func (f *AzureQueue) Delete(messageId string, popReceipt string) error {
+	delete := DeleteQueue(f.connString, f.queueName, messageId, popReceipt)
+	if delete.Error != nil {
+		return delete.Error
+	}
+	if delete.StatusCode != 204 {
+		return errors.New("delete from " + f.queueName + " failed: " + strconv.Itoa(delete.StatusCode) + " " + string(delete.ResponseBody))
+	}
+	return nil
+}
+
+func (f *AzureQueue) Update(messageId string, popReceipt string, messageText string, visibilityTimeout time.Duration) (string, error) {
+	post := UpdateQueueMessage(f.connString, f.queueName, messageId, popReceipt, messageText, int(visibilityTimeout/time.Second))
+	if post.Error != nil {
+		return "", post.Error
+	}
+	if post.StatusCode != 204 {
+		return "", errors.New("update in " + f.queueName + " failed: " + strconv.Itoa(post.StatusCode) + " " + string(post.ResponseBody))
+	}
+	return post.Response.Header.Get("x-ms-popreceipt"), nil
+}
+
+func (f *AzureQueue) Peek(count int) ([]QueueMessage, error) {
+	return peekQueueMessages(f.connString, f.queueName, count)
+}
+
+func (f *AzureQueue) Count() (int, error) {
+	return GetQueueMessageCount(f.connString, f.queueName)
+}
+
+func (f *AzureQueue) Clear() error {
+	delete := ClearQueue(f.connString, f.queueName)
+	if delete.Error != nil {
+		return delete.Error
+	}
+	if delete.StatusCode != 204 {
+		return errors.New("clear " + f.queueName + " failed: " + strconv.Itoa(delete.StatusCode) + " " + string(delete.ResponseBody))
+	}
+	return nil
+}
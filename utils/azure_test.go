@@ -0,0 +1,54 @@
+package utils_test
+
+import (
+	"encoding/base64"
+	"main/utils"
+	"main/utils/storagetest"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSharedKeySigning(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+
+	if _, err := utils.CreateContainerIfNotExists(connString, "signed"); err != nil {
+		t.Fatal(err)
+	}
+	// names that need escaping must be stored as given and signed as
+	// they are sent
+	names := []string{"a dir/with space+plus?.txt", "hash#1.txt", "percent%2F.txt", "plain.txt", "semi;colon,comma.txt", "ünïcode/名前.txt"}
+	for _, name := range names {
+		if post := utils.PutBlob(connString, "signed", name, "content of "+name); post.Error != nil || post.StatusCode != http.StatusCreated {
+			t.Fatalf("put %q: %v %d %s", name, post.Error, post.StatusCode, post.ResponseBody)
+		}
+		if get := utils.GetBlob(connString, "signed", name); get.StatusCode != http.StatusOK || string(get.ResponseBody) != "content of "+name {
+			t.Fatalf("get %q: %d %q", name, get.StatusCode, get.ResponseBody)
+		}
+	}
+	blobs, _, err := utils.ListAllBlobs(connString, "signed", utils.ListBlobsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []string
+	for _, blob := range blobs {
+		stored = append(stored, blob.Name)
+	}
+	if !reflect.DeepEqual(stored, names) {
+		t.Fatalf("stored names %q, want %q", stored, names)
+	}
+
+	wrongKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 64)))
+	i := strings.Index(connString, "AccountKey=")
+	j := i + strings.Index(connString[i:], ";")
+	wrong := connString[:i] + "AccountKey=" + wrongKey + connString[j:]
+	if get := utils.GetBlob(wrong, "signed", "plain.txt"); get.StatusCode != http.StatusForbidden {
+		t.Fatalf("wrong key: got status %d", get.StatusCode)
+	}
+	if _, err := utils.OpenQueue(wrong, "signed").Send("hello", 0); err == nil {
+		t.Fatal("wrong key: queue send succeeded")
+	}
+}
@@ -1,25 +1,289 @@
 package utils
 
-import "fmt"
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
 
-func PutBlob(connString string, container string, blobName, text string) (res *HttpPost) {
+// PutBlobOptions are the optional settings of PutBlob.
+type PutBlobOptions struct {
+	Headers  BlobHTTPHeaders
+	Metadata map[string]string
+	// Tags are blob index tags, searchable with FindBlobsByTags.
+	Tags map[string]string
+	// AccessTier is Hot, Cool or Archive. Empty leaves the account default.
+	AccessTier string
+	// IfNoneMatch of "*" only creates the blob if it doesn't exist yet.
+	IfNoneMatch string
+	// IfMatch only overwrites the blob while its ETag still matches.
+	IfMatch string
+	// LeaseID is required to overwrite a leased blob.
+	LeaseID string
+}
+
+// PutBlob uploads text as a block blob. The Content-MD5 of the body is
+// always sent so that the service rejects a corrupted upload.
+func PutBlob(connString string, container string, blobName, text string, params ...PutBlobOptions) (res *HttpPost) {
 
 	credential, err := NewSharedKeyCredential(connString)
 	if err != nil {
 		fmt.Println("NewSharedKeyCredential err")
 	}
 
-	URI := "https://" + credential.AccountName() + ".blob.core.windows.net/" + container + "/" + blobName
+	var opts PutBlobOptions
+	if len(params) > 0 {
+		opts = params[0]
+	}
+
+	URI := credential.BlobURL(container + "/" + blobName)
 
 	post := &HttpPost{
 		URI:         URI,
 		RequestBody: []byte(text),
 	}
-	var header map[string][]string
-	header = make(map[string][]string)
-	header["x-ms-blob-type"] = []string{"BlockBlob"}
+	sum := md5.Sum(post.RequestBody)
+	header := http.Header{}
+	header.Set("x-ms-blob-type", "BlockBlob")
+	header.Set(headerContentMD5, base64.StdEncoding.EncodeToString(sum[:]))
+	if len(opts.Tags) > 0 {
+		header.Set("x-ms-tags", encodeTags(opts.Tags))
+	}
+	if opts.AccessTier != "" {
+		header.Set("x-ms-access-tier", opts.AccessTier)
+	}
+	if opts.IfNoneMatch != "" {
+		header.Set(headerIfNoneMatch, opts.IfNoneMatch)
+	}
+	if opts.IfMatch != "" {
+		header.Set(headerIfMatch, opts.IfMatch)
+	}
+	if opts.LeaseID != "" {
+		header.Set("x-ms-lease-id", opts.LeaseID)
+	}
+	httpHeadersHeader(opts.Headers, header)
+	metadataHeader(opts.Metadata, header)
 	err = credential.HttpPutRequest(post, header)
 	post.ResponseBody = []byte(XML2JSON(string(post.ResponseBody)))
 
 	return post
 }
+
+func GetBlob(connString string, container string, blobName string) (res *HttpGet) {
+
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		fmt.Println("NewSharedKeyCredential err")
+	}
+
+	URI := credential.BlobURL(container + "/" + blobName)
+
+	get := &HttpGet{
+		URI: URI,
+	}
+	err = credential.HttpGetRequest(get)
+
+	return get
+}
+
+func DeleteBlob(connString string, container string, blobName string) (res *HttpGet) {
+
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		fmt.Println("NewSharedKeyCredential err")
+	}
+
+	URI := credential.BlobURL(container + "/" + blobName)
+
+	delete := &HttpGet{
+		URI: URI,
+	}
+	err = credential.HttpDeleteRequest(delete)
+	delete.ResponseBody = []byte(XML2JSON(string(delete.ResponseBody)))
+
+	return delete
+}
+
+// BlobProperties are the system properties and user metadata of a blob.
+type BlobProperties struct {
+	ContentLength      int64
+	ContentType        string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentDisposition string
+	CacheControl       string
+	ContentMD5         string
+	ETag               string
+	LastModified       time.Time
+	CreationTime       time.Time
+	BlobType           string
+	AccessTier         string
+	LeaseStatus        string
+	LeaseState         string
+	Metadata           map[string]string
+}
+
+// BlobHTTPHeaders are the standard HTTP properties that can be set on a blob.
+type BlobHTTPHeaders struct {
+	ContentType        string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentDisposition string
+	CacheControl       string
+	ContentMD5         string
+}
+
+func blobPropertiesFromHeader(header http.Header) *BlobProperties {
+	props := &BlobProperties{
+		ContentType:        header.Get(headerContentType),
+		ContentEncoding:    header.Get(headerContentEncoding),
+		ContentLanguage:    header.Get(headerContentLanguage),
+		ContentDisposition: header.Get(headerContentDisposition),
+		CacheControl:       header.Get(headerCacheControl),
+		ContentMD5:         header.Get(headerContentMD5),
+		ETag:               header.Get("ETag"),
+		BlobType:           header.Get("x-ms-blob-type"),
+		AccessTier:         header.Get("x-ms-access-tier"),
+		LeaseStatus:        header.Get("x-ms-lease-status"),
+		LeaseState:         header.Get("x-ms-lease-state"),
+		Metadata:           metadataFromHeader(header),
+	}
+	props.ContentLength, _ = strconv.ParseInt(header.Get(headerContentLength), 10, 64)
+	props.LastModified, _ = time.Parse(http.TimeFormat, header.Get("Last-Modified"))
+	props.CreationTime, _ = time.Parse(http.TimeFormat, header.Get("x-ms-creation-time"))
+	// A blob's own MD5 is reported separately from the MD5 of a ranged response.
+	if md5 := header.Get("x-ms-blob-content-md5"); md5 != "" {
+		props.ContentMD5 = md5
+	}
+	return props
+}
+
+// metadataFromHeader collects x-ms-meta-* headers. Names are returned in
+// lower case because HTTP header names are not case-preserving.
+func metadataFromHeader(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-ms-meta-") && len(v) > 0 {
+			metadata[strings.TrimPrefix(name, "x-ms-meta-")] = v[0]
+		}
+	}
+	return metadata
+}
+
+func httpHeadersHeader(headers BlobHTTPHeaders, header map[string][]string) {
+	for k, v := range map[string]string{
+		"x-ms-blob-content-type":        headers.ContentType,
+		"x-ms-blob-content-encoding":    headers.ContentEncoding,
+		"x-ms-blob-content-language":    headers.ContentLanguage,
+		"x-ms-blob-content-disposition": headers.ContentDisposition,
+		"x-ms-blob-cache-control":       headers.CacheControl,
+		"x-ms-blob-content-md5":         headers.ContentMD5,
+	} {
+		if v != "" {
+			header[k] = []string{v}
+		}
+	}
+}
+
+func metadataHeader(metadata map[string]string, header map[string][]string) {
+	for k, v := range metadata {
+		header["x-ms-meta-"+k] = []string{v}
+	}
+}
+
+// GetBlobStream starts a download and returns the blob body unread, along
+// with the blob's properties. The caller must close the reader.
+func GetBlobStream(connString string, container string, blobName string) (io.ReadCloser, *BlobProperties, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL(container+"/"+blobName), nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, newStorageError(resp)
+	}
+	return resp.Body, blobPropertiesFromHeader(resp.Header), nil
+}
+
+func GetBlobProperties(connString string, container string, blobName string) (*BlobProperties, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := credential.HttpStreamRequest("HEAD", credential.BlobURL(container+"/"+blobName), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	resp.Body.Close()
+	return blobPropertiesFromHeader(resp.Header), nil
+}
+
+func BlobExists(connString string, container string, blobName string) (bool, error) {
+	_, err := GetBlobProperties(connString, container, blobName)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SetBlobProperties replaces all of the blob's HTTP headers; any left empty are cleared.
+func SetBlobProperties(connString string, container string, blobName string, headers BlobHTTPHeaders) error {
+	header := make(map[string][]string)
+	httpHeadersHeader(headers, header)
+	return blobCompRequest(connString, container, blobName, "properties", header)
+}
+
+func GetBlobMetadata(connString string, container string, blobName string) (map[string]string, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL(container+"/"+blobName, "comp=metadata"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	resp.Body.Close()
+	return metadataFromHeader(resp.Header), nil
+}
+
+// SetBlobMetadata replaces all of the blob's metadata.
+func SetBlobMetadata(connString string, container string, blobName string, metadata map[string]string) error {
+	header := make(map[string][]string)
+	metadataHeader(metadata, header)
+	return blobCompRequest(connString, container, blobName, "metadata", header)
+}
+
+func blobCompRequest(connString string, container string, blobName string, comp string, header map[string][]string) error {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return err
+	}
+
+	resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(container+"/"+blobName, "comp="+comp), nil, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newStorageError(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxRangeMD5Size is the largest range the service returns an MD5 for.
+const MaxRangeMD5Size = 4 * 1024 * 1024
+
+// ErrMD5Mismatch is returned when downloaded data doesn't match its MD5.
+var ErrMD5Mismatch = errors.New("downloaded data does not match its Content-MD5")
+
+type DownloadOptions struct {
+	// BlockSize is the size of each ranged request. Defaults to, and is at
+	// most, MaxRangeMD5Size so that every range is checked by MD5.
+	BlockSize int64
+	// Concurrency is the number of ranges downloaded at once. Defaults to 4.
+	Concurrency int
+	// MaxRetries is how often a failed range is requested again. Defaults to 3.
+	MaxRetries int
+	// SkipMD5 disables the check of the whole blob against its Content-MD5.
+	SkipMD5 bool
+}
+
+// GetBlobRange starts a download of count bytes from offset, or of the
+// rest of the blob when count is not positive. The returned properties
+// describe the whole blob. The caller must close the reader.
+func GetBlobRange(connString string, container string, blobName string, offset int64, count int64) (io.ReadCloser, *BlobProperties, error) {
+	resp, err := getBlobRange(connString, container, blobName, offset, count, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	props := blobPropertiesFromHeader(resp.Header)
+	// Content-Range is "bytes <first>-<last>/<total>".
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			props.ContentLength, _ = strconv.ParseInt(contentRange[i+1:], 10, 64)
+		}
+	}
+	return resp.Body, props, nil
+}
+
+func getBlobRange(connString string, container string, blobName string, offset int64, count int64, header map[string][]string) (*http.Response, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	if header == nil {
+		header = make(map[string][]string)
+	}
+	byteRange := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if count > 0 {
+		byteRange += strconv.FormatInt(offset+count-1, 10)
+	}
+	header[headerRange] = []string{byteRange}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL(container+"/"+blobName), nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	return resp, nil
+}
+
+// DownloadBlob downloads the blob in parallel ranges into w. Each range is
+// checked against the MD5 the service computes for it and the download
+// fails if the blob changes meanwhile. When w is also an io.ReaderAt and
+// the blob has a Content-MD5, the written data is checked against it.
+func DownloadBlob(connString string, container string, blobName string, w io.WriterAt, opts DownloadOptions) (*BlobProperties, error) {
+	props, err := GetBlobProperties(connString, container, blobName)
+	if err != nil {
+		return nil, err
+	}
+	if err := downloadRanges(connString, container, blobName, w, props, opts, nil, nil); err != nil {
+		return props, err
+	}
+	return props, verifyDownload(w, props, opts)
+}
+
+// downloadProgress is saved next to a partial download so that it can be
+// resumed.
+type downloadProgress struct {
+	ETag      string         `json:"etag"`
+	Size      int64          `json:"size"`
+	BlockSize int64          `json:"blockSize"`
+	Done      map[int64]bool `json:"done"`
+}
+
+// DownloadBlobToFile downloads the blob to path. Data is written to
+// path.partial with its progress in path.partial.json, so an interrupted
+// download resumes where it stopped as long as the blob is unchanged. The
+// file is renamed to path once it is complete and verified.
+func DownloadBlobToFile(connString string, container string, blobName string, path string, opts DownloadOptions) (*BlobProperties, error) {
+	props, err := GetBlobProperties(connString, container, blobName)
+	if err != nil {
+		return nil, err
+	}
+	opts.BlockSize = downloadBlockSize(opts)
+
+	partialPath := path + ".partial"
+	progressPath := partialPath + ".json"
+	progress := downloadProgress{ETag: props.ETag, Size: props.ContentLength, BlockSize: opts.BlockSize, Done: make(map[int64]bool)}
+	if content, err := ioutil.ReadFile(progressPath); err == nil {
+		var saved downloadProgress
+		if json.Unmarshal(content, &saved) == nil && saved.ETag == progress.ETag && saved.Size == progress.Size && saved.BlockSize == progress.BlockSize && saved.Done != nil {
+			progress.Done = saved.Done
+		}
+	}
+	if _, err := os.Stat(partialPath); err != nil {
+		progress.Done = make(map[int64]bool)
+	}
+
+	file, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return props, err
+	}
+	defer file.Close()
+
+	var progressLock sync.Mutex
+	saveProgress := func(offset int64) {
+		progressLock.Lock()
+		defer progressLock.Unlock()
+		progress.Done[offset] = true
+		if content, err := json.Marshal(progress); err == nil {
+			_ = ioutil.WriteFile(progressPath, content, 0644)
+		}
+	}
+	// the workers add to progress.Done, so the feeder reads a copy
+	done := make(map[int64]bool, len(progress.Done))
+	for offset := range progress.Done {
+		done[offset] = true
+	}
+	if err := downloadRanges(connString, container, blobName, file, props, opts, done, saveProgress); err != nil {
+		return props, err
+	}
+	if err := file.Truncate(props.ContentLength); err != nil {
+		return props, err
+	}
+	if err := verifyDownload(file, props, opts); err != nil {
+		_ = os.Remove(progressPath)
+		return props, err
+	}
+	if err := file.Close(); err != nil {
+		return props, err
+	}
+	if err := os.Rename(partialPath, path); err != nil {
+		return props, err
+	}
+	_ = os.Remove(progressPath)
+	return props, nil
+}
+
+func downloadBlockSize(opts DownloadOptions) int64 {
+	if opts.BlockSize <= 0 || opts.BlockSize > MaxRangeMD5Size {
+		return MaxRangeMD5Size
+	}
+	return opts.BlockSize
+}
+
+// downloadRanges fetches every range of the blob not in done, writing each
+// to w and calling completed with its offset.
+func downloadRanges(connString string, container string, blobName string, w io.WriterAt, props *BlobProperties, opts DownloadOptions, done map[int64]bool, completed func(offset int64)) error {
+	blockSize := downloadBlockSize(opts)
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+
+	offsets := make(chan int64)
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		errLock.Lock()
+		defer errLock.Unlock()
+		return firstErr != nil
+	}
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				if failed() {
+					continue
+				}
+				count := blockSize
+				if offset+count > props.ContentLength {
+					count = props.ContentLength - offset
+				}
+				var err error
+				for attempt := 0; attempt <= maxRetries; attempt++ {
+					if attempt > 0 {
+						time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+					}
+					err = downloadRange(connString, container, blobName, w, offset, count, props.ETag)
+					// a changed blob won't get better by retrying
+					if err == nil || ErrorStatus(err) == http.StatusPreconditionFailed {
+						break
+					}
+				}
+				if err != nil {
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = errors.New("range at " + strconv.FormatInt(offset, 10) + ": " + err.Error())
+					}
+					errLock.Unlock()
+					continue
+				}
+				if completed != nil {
+					completed(offset)
+				}
+			}
+		}()
+	}
+
+	for offset := int64(0); offset < props.ContentLength && !failed(); offset += blockSize {
+		if !done[offset] {
+			offsets <- offset
+		}
+	}
+	close(offsets)
+	wg.Wait()
+	return firstErr
+}
+
+// downloadRange copies one range into w after checking its MD5. etag makes
+// the request fail if the blob has been modified.
+func downloadRange(connString string, container string, blobName string, w io.WriterAt, offset int64, count int64, etag string) error {
+	header := map[string][]string{"x-ms-range-get-content-md5": {"true"}}
+	if etag != "" {
+		header[headerIfMatch] = []string{etag}
+	}
+	resp, err := getBlobRange(connString, container, blobName, offset, count, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, count+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != count {
+		return errors.New("short range: got " + strconv.Itoa(len(data)) + " of " + strconv.FormatInt(count, 10) + " bytes")
+	}
+	if expected := resp.Header.Get(headerContentMD5); expected != "" {
+		sum := md5.Sum(data)
+		if base64.StdEncoding.EncodeToString(sum[:]) != expected {
+			return ErrMD5Mismatch
+		}
+	}
+	_, err = w.WriteAt(data, offset)
+	return err
+}
+
+// verifyDownload checks the data written to w against the blob's MD5.
+func verifyDownload(w io.WriterAt, props *BlobProperties, opts DownloadOptions) error {
+	r, ok := w.(io.ReaderAt)
+	if opts.SkipMD5 || !ok || props.ContentMD5 == "" {
+		return nil
+	}
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(r, 0, props.ContentLength)); err != nil {
+		return err
+	}
+	if base64.StdEncoding.EncodeToString(hash.Sum(nil)) != props.ContentMD5 {
+		return ErrMD5Mismatch
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package utils_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"main/utils"
+	"main/utils/storagetest"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadBlobToFile(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+
+	data := make([]byte, 512*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	if _, err := utils.CreateContainerIfNotExists(connString, "downloads"); err != nil {
+		t.Fatal(err)
+	}
+	if post := utils.PutBlob(connString, "downloads", "data.bin", string(data)); post.Error != nil || post.StatusCode != 201 {
+		t.Fatalf("put: %v %d", post.Error, post.StatusCode)
+	}
+
+	// many small ranges at once, so that the race detector sees the
+	// workers sharing the progress of the download
+	path := filepath.Join(t.TempDir(), "data.bin")
+	props, err := utils.DownloadBlobToFile(connString, "downloads", "data.bin", path, utils.DownloadOptions{BlockSize: 1024, Concurrency: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props.ContentLength != int64(len(data)) {
+		t.Fatalf("content length %d", props.ContentLength)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, data) {
+		t.Fatal("downloaded content differs")
+	}
+}
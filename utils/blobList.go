@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ListBlobsOptions struct {
+	Prefix string
+	// Delimiter groups names sharing a prefix up to the delimiter into Prefixes.
+	Delimiter string
+	// Marker continues a listing from the NextMarker of a previous page.
+	Marker string
+	// MaxResults defaults to the service's 5000.
+	MaxResults      int
+	IncludeMetadata bool
+}
+
+type BlobItem struct {
+	Name       string
+	Properties *BlobProperties
+}
+
+// ListBlobsResult is one page of a blob listing. More pages follow while
+// NextMarker is not empty.
+type ListBlobsResult struct {
+	Blobs      []BlobItem
+	Prefixes   []string
+	NextMarker string
+}
+
+type listBlobsXML struct {
+	XMLName xml.Name `xml:"EnumerationResults"`
+	Blobs   []struct {
+		Name       string            `xml:"Name"`
+		Properties blobPropertiesXML `xml:"Properties"`
+		Metadata   blobMetadataXML   `xml:"Metadata"`
+	} `xml:"Blobs>Blob"`
+	Prefixes []struct {
+		Name string `xml:"Name"`
+	} `xml:"Blobs>BlobPrefix"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+type blobPropertiesXML struct {
+	CreationTime       string `xml:"Creation-Time"`
+	LastModified       string `xml:"Last-Modified"`
+	ETag               string `xml:"Etag"`
+	ContentLength      int64  `xml:"Content-Length"`
+	ContentType        string `xml:"Content-Type"`
+	ContentEncoding    string `xml:"Content-Encoding"`
+	ContentLanguage    string `xml:"Content-Language"`
+	ContentDisposition string `xml:"Content-Disposition"`
+	CacheControl       string `xml:"Cache-Control"`
+	ContentMD5         string `xml:"Content-MD5"`
+	BlobType           string `xml:"BlobType"`
+	AccessTier         string `xml:"AccessTier"`
+	LeaseStatus        string `xml:"LeaseStatus"`
+	LeaseState         string `xml:"LeaseState"`
+}
+
+// blobMetadataXML reads a <Metadata> element whose children are arbitrary
+// name/value pairs.
+type blobMetadataXML map[string]string
+
+func (f *blobMetadataXML) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*f = make(blobMetadataXML)
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var value string
+			if err := d.DecodeElement(&value, &t); err != nil {
+				return err
+			}
+			(*f)[strings.ToLower(t.Name.Local)] = value
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// ListBlobs returns one page of the blobs in container.
+func ListBlobs(connString string, container string, opts ListBlobsOptions) (*ListBlobsResult, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("restype", "container")
+	query.Set("comp", "list")
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.Delimiter != "" {
+		query.Set("delimiter", opts.Delimiter)
+	}
+	if opts.Marker != "" {
+		query.Set("marker", opts.Marker)
+	}
+	if opts.MaxResults > 0 {
+		query.Set("maxresults", strconv.Itoa(opts.MaxResults))
+	}
+	if opts.IncludeMetadata {
+		query.Set("include", "metadata")
+	}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL(container, query.Encode()), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseListBlobs(body)
+}
+
+func parseListBlobs(body []byte) (*ListBlobsResult, error) {
+	var list listBlobsXML
+	if err := xml.Unmarshal(body, &list); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	result := &ListBlobsResult{NextMarker: list.NextMarker}
+	for _, blob := range list.Blobs {
+		p := blob.Properties
+		props := &BlobProperties{
+			ContentLength:      p.ContentLength,
+			ContentType:        p.ContentType,
+			ContentEncoding:    p.ContentEncoding,
+			ContentLanguage:    p.ContentLanguage,
+			ContentDisposition: p.ContentDisposition,
+			CacheControl:       p.CacheControl,
+			ContentMD5:         p.ContentMD5,
+			ETag:               p.ETag,
+			BlobType:           p.BlobType,
+			AccessTier:         p.AccessTier,
+			LeaseStatus:        p.LeaseStatus,
+			LeaseState:         p.LeaseState,
+			Metadata:           map[string]string(blob.Metadata),
+		}
+		props.LastModified, _ = time.Parse(http.TimeFormat, p.LastModified)
+		props.CreationTime, _ = time.Parse(http.TimeFormat, p.CreationTime)
+		result.Blobs = append(result.Blobs, BlobItem{Name: blob.Name, Properties: props})
+	}
+	for _, prefix := range list.Prefixes {
+		result.Prefixes = append(result.Prefixes, prefix.Name)
+	}
+	return result, nil
+}
+
+// ListAllBlobs follows NextMarker until every matching blob has been listed.
+func ListAllBlobs(connString string, container string, opts ListBlobsOptions) ([]BlobItem, []string, error) {
+	var blobs []BlobItem
+	var prefixes []string
+	for {
+		page, err := ListBlobs(connString, container, opts)
+		if err != nil {
+			return blobs, prefixes, err
+		}
+		blobs = append(blobs, page.Blobs...)
+		prefixes = append(prefixes, page.Prefixes...)
+		if page.NextMarker == "" {
+			return blobs, prefixes, nil
+		}
+		opts.Marker = page.NextMarker
+	}
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BlobLock is a distributed lock held as a lease on a blob. While it is
+// held the lease is renewed in the background, and the context returned by
+// Lock is cancelled as soon as the lease is released or can no longer be
+// renewed.
+type BlobLock struct {
+	// LeaseDuration is between 15s and 60s. Defaults to 30s.
+	LeaseDuration time.Duration
+	// RetryInterval is how often Lock tries to take a held lock. Defaults to 10s.
+	RetryInterval time.Duration
+
+	connString string
+	container  string
+	blobName   string
+
+	lock    sync.Mutex
+	leaseID string
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewBlobLock(connString string, container string, blobName string) *BlobLock {
+	return &BlobLock{
+		LeaseDuration: 30 * time.Second,
+		RetryInterval: 10 * time.Second,
+		connString:    connString,
+		container:     container,
+		blobName:      blobName,
+	}
+}
+
+// ErrLockHeld is returned by TryLock when another holder has the lease.
+var ErrLockHeld = errors.New("lock is held by another instance")
+
+// TryLock takes the lock if it is free. The returned context lives until
+// the lock is lost or Unlock is called.
+func (f *BlobLock) TryLock(ctx context.Context) (context.Context, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.leaseID != "" {
+		return nil, errors.New("lock is already held by this BlobLock")
+	}
+
+	if err := f.ensureBlob(); err != nil {
+		return nil, err
+	}
+	leaseID, err := AcquireBlobLease(f.connString, f.container, f.blobName, int(f.LeaseDuration/time.Second), "")
+	if ErrorStatus(err) == http.StatusConflict {
+		return nil, ErrLockHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	f.leaseID = leaseID
+	f.cancel = cancel
+	f.done = make(chan struct{})
+	go f.renew(lockCtx, leaseID, cancel, f.done)
+	return lockCtx, nil
+}
+
+// Lock waits until the lock is taken or ctx is done.
+func (f *BlobLock) Lock(ctx context.Context) (context.Context, error) {
+	for {
+		lockCtx, err := f.TryLock(ctx)
+		if err != ErrLockHeld {
+			return lockCtx, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(f.RetryInterval):
+		}
+	}
+}
+
+// Unlock stops renewing and releases the lease.
+func (f *BlobLock) Unlock() error {
+	f.lock.Lock()
+	leaseID, cancel, done := f.leaseID, f.cancel, f.done
+	f.leaseID, f.cancel, f.done = "", nil, nil
+	f.lock.Unlock()
+
+	if leaseID == "" {
+		return nil
+	}
+	cancel()
+	<-done
+	return ReleaseBlobLease(f.connString, f.container, f.blobName, leaseID)
+}
+
+// ReadContent returns the content of the lock blob. Holders can keep state
+// there that the next holder picks up, e.g. what has already been done.
+func (f *BlobLock) ReadContent() ([]byte, error) {
+	body, _, err := GetBlobStream(f.connString, f.container, f.blobName)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// WriteContent replaces the content of the lock blob. It fails unless the
+// lock is held, so a holder that lost the lease cannot overwrite the state
+// of the next one.
+func (f *BlobLock) WriteContent(content []byte) error {
+	f.lock.Lock()
+	leaseID := f.leaseID
+	f.lock.Unlock()
+	if leaseID == "" {
+		return errors.New("lock is not held")
+	}
+
+	credential, err := NewSharedKeyCredential(f.connString)
+	if err != nil {
+		return err
+	}
+	header := map[string][]string{
+		"x-ms-blob-type": {"BlockBlob"},
+		"x-ms-lease-id":  {leaseID},
+	}
+	resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(f.container+"/"+f.blobName), content, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return newStorageError(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RunAsLeader repeatedly takes the lock and runs fn while holding it, until
+// ctx is done. fn must return when its context is cancelled, which happens
+// when leadership is lost.
+func (f *BlobLock) RunAsLeader(ctx context.Context, fn func(ctx context.Context)) error {
+	for {
+		leaderCtx, err := f.Lock(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// storage errors are retried like a held lock
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(f.RetryInterval):
+			}
+			continue
+		}
+		fn(leaderCtx)
+		_ = f.Unlock()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// renew keeps the lease alive at a third of its duration. Transient
+// failures are retried until the lease would have expired; a lease taken
+// over by someone else ends the lock at once.
+func (f *BlobLock) renew(ctx context.Context, leaseID string, cancel context.CancelFunc, done chan struct{}) {
+	defer close(done)
+	defer cancel()
+
+	interval := f.LeaseDuration / 3
+	expires := time.Now().Add(f.LeaseDuration)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := RenewBlobLease(f.connString, f.container, f.blobName, leaseID)
+		if err == nil {
+			expires = time.Now().Add(f.LeaseDuration)
+			continue
+		}
+		if status := ErrorStatus(err); status == http.StatusConflict || status == http.StatusPreconditionFailed || status == http.StatusNotFound {
+			return
+		}
+		if time.Now().Add(interval).After(expires) {
+			return
+		}
+	}
+}
+
+// ensureBlob creates the empty lock blob unless it already exists.
+func (f *BlobLock) ensureBlob() error {
+	credential, err := NewSharedKeyCredential(f.connString)
+	if err != nil {
+		return err
+	}
+
+	header := map[string][]string{
+		"x-ms-blob-type":  {"BlockBlob"},
+		headerIfNoneMatch: {"*"},
+	}
+	resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(f.container+"/"+f.blobName), nil, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		if _, err := CreateContainerIfNotExists(f.connString, f.container); err != nil {
+			return err
+		}
+		if resp, err = credential.HttpStreamRequest("PUT", credential.BlobURL(f.container+"/"+f.blobName), nil, header); err != nil {
+			return err
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusConflict, http.StatusPreconditionFailed:
+		resp.Body.Close()
+		return nil
+	}
+	return newStorageError(resp)
+}
@@ -0,0 +1,48 @@
+package utils_test
+
+import (
+	"context"
+	"main/utils"
+	"main/utils/storagetest"
+	"testing"
+)
+
+func TestBlobLock(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+	if _, err := utils.CreateContainerIfNotExists(connString, "locks"); err != nil {
+		t.Fatal(err)
+	}
+
+	first := utils.NewBlobLock(connString, "locks", "leader")
+	second := utils.NewBlobLock(connString, "locks", "leader")
+	ctx, err := first.TryLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.TryLock(context.Background()); err != utils.ErrLockHeld {
+		t.Fatalf("second holder: %v", err)
+	}
+	if err := second.WriteContent([]byte("stolen")); err == nil {
+		t.Fatal("WriteContent succeeded without the lock")
+	}
+	if err := first.WriteContent([]byte("state")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("lock context not cancelled by Unlock")
+	}
+	if _, err := second.TryLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Unlock()
+	content, err := second.ReadContent()
+	if err != nil || string(content) != "state" {
+		t.Fatalf("content: %q %v", content, err)
+	}
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// blobTagsXML is the body of Get and Set Blob Tags.
+type blobTagsXML struct {
+	XMLName xml.Name `xml:"Tags"`
+	TagSet  []tagXML `xml:"TagSet>Tag"`
+}
+
+type tagXML struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+func newBlobTagsXML(tags map[string]string) blobTagsXML {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var body blobTagsXML
+	for _, k := range keys {
+		body.TagSet = append(body.TagSet, tagXML{Key: k, Value: tags[k]})
+	}
+	return body
+}
+
+func (f blobTagsXML) tags() map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range f.TagSet {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
+
+// encodeTags formats tags for the x-ms-tags header.
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// SetBlobTags replaces the blob index tags of the blob. Tags can be
+// searched across containers with FindBlobsByTags.
+func SetBlobTags(connString string, container string, blobName string, tags map[string]string) error {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return err
+	}
+
+	body, err := xml.Marshal(newBlobTagsXML(tags))
+	if err != nil {
+		return err
+	}
+	header := map[string][]string{headerContentType: {"application/xml"}}
+	resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(container+"/"+blobName, "comp=tags"), append([]byte(xml.Header), body...), header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return newStorageError(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func GetBlobTags(connString string, container string, blobName string) (map[string]string, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL(container+"/"+blobName, "comp=tags"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var tags blobTagsXML
+	if err := xml.Unmarshal(body, &tags); err != nil {
+		return nil, err
+	}
+	return tags.tags(), nil
+}
+
+type FindBlobsOptions struct {
+	// Marker continues a search from the NextMarker of a previous page.
+	Marker string
+	// MaxResults defaults to the service's 5000.
+	MaxResults int
+}
+
+type TaggedBlob struct {
+	Container string
+	Name      string
+	// Tags are the tags that matched the expression.
+	Tags map[string]string
+}
+
+// FindBlobsResult is one page of a tag search. More pages follow while
+// NextMarker is not empty.
+type FindBlobsResult struct {
+	Blobs      []TaggedBlob
+	NextMarker string
+}
+
+type findBlobsXML struct {
+	XMLName xml.Name `xml:"EnumerationResults"`
+	Blobs   []struct {
+		Name          string      `xml:"Name"`
+		ContainerName string      `xml:"ContainerName"`
+		Tags          blobTagsXML `xml:"Tags"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// FindBlobsByTags returns one page of the blobs in the account whose tags
+// match where, e.g. "status" = 'failed' AND "date" >= '2024-01-01'. A
+// clause of @container = 'name' limits the search to one container.
+func FindBlobsByTags(connString string, where string, opts FindBlobsOptions) (*FindBlobsResult, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("comp", "blobs")
+	query.Set("where", where)
+	if opts.Marker != "" {
+		query.Set("marker", opts.Marker)
+	}
+	if opts.MaxResults > 0 {
+		query.Set("maxresults", strconv.Itoa(opts.MaxResults))
+	}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL("", query.Encode()), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var list findBlobsXML
+	if err := xml.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	result := &FindBlobsResult{NextMarker: list.NextMarker}
+	for _, blob := range list.Blobs {
+		result.Blobs = append(result.Blobs, TaggedBlob{Container: blob.ContainerName, Name: blob.Name, Tags: blob.Tags.tags()})
+	}
+	return result, nil
+}
+
+// FindAllBlobsByTags follows NextMarker until every matching blob has been
+// found.
+func FindAllBlobsByTags(connString string, where string) ([]TaggedBlob, error) {
+	var blobs []TaggedBlob
+	opts := FindBlobsOptions{}
+	for {
+		page, err := FindBlobsByTags(connString, where, opts)
+		if err != nil {
+			return blobs, err
+		}
+		blobs = append(blobs, page.Blobs...)
+		if page.NextMarker == "" {
+			return blobs, nil
+		}
+		opts.Marker = page.NextMarker
+	}
+}
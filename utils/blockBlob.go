@@ -0,0 +1,254 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBlockSize is the block size UploadBlob uses unless told otherwise.
+	DefaultBlockSize = 4 * 1024 * 1024
+	// MaxBlockSize is the largest block Put Block accepts.
+	MaxBlockSize = 4000 * 1024 * 1024
+	// MaxBlocks is the most blocks a blob can be committed from.
+	MaxBlocks = 50000
+)
+
+type UploadOptions struct {
+	// BlockSize defaults to DefaultBlockSize.
+	BlockSize int
+	// Concurrency is the number of blocks uploaded at once. Defaults to 4.
+	// At most Concurrency+1 blocks are held in memory.
+	Concurrency int
+	// MaxRetries is how often a failed block is retried. Defaults to 3.
+	MaxRetries int
+	// UploadID names the blocks of this upload. Re-running an interrupted
+	// upload with the same UploadID and block size skips blocks that were
+	// already staged with the same content. A random ID is used when it is
+	// empty. It must be at most 38 bytes long.
+	UploadID string
+	Headers  BlobHTTPHeaders
+	Metadata map[string]string
+}
+
+// Block is an entry of a blob's block list.
+type Block struct {
+	Name string `xml:"Name"`
+	Size int64  `xml:"Size"`
+}
+
+type BlockList struct {
+	CommittedBlocks   []Block `xml:"CommittedBlocks>Block"`
+	UncommittedBlocks []Block `xml:"UncommittedBlocks>Block"`
+}
+
+type uploadBlock struct {
+	id   string
+	data []byte
+}
+
+// UploadBlob reads r to the end and uploads it as a block blob, staging
+// blocks in parallel with Put Block and committing them with Put Block
+// List. Every block carries a Content-MD5 that the service verifies.
+func UploadBlob(connString string, container string, blobName string, r io.Reader, opts UploadOptions) error {
+	blockSize := opts.BlockSize
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	if blockSize > MaxBlockSize {
+		return errors.New("block size exceeds MaxBlockSize")
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+	uploadID := opts.UploadID
+	if uploadID == "" {
+		uploadID = randomHex(8)
+	}
+	if len(uploadID) > 38 {
+		// block IDs are limited to 64 bytes before encoding
+		return errors.New("upload id exceeds 38 bytes")
+	}
+
+	staged := make(map[string]int64)
+	if opts.UploadID != "" {
+		list, err := GetBlockList(connString, container, blobName, "uncommitted")
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+		if list != nil {
+			for _, block := range list.UncommittedBlocks {
+				staged[block.Name] = block.Size
+			}
+		}
+	}
+
+	blocks := make(chan uploadBlock)
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		errLock.Lock()
+		defer errLock.Unlock()
+		return firstErr != nil
+	}
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range blocks {
+				if failed() {
+					continue
+				}
+				var err error
+				for attempt := 0; attempt <= maxRetries; attempt++ {
+					if attempt > 0 {
+						time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+					}
+					if err = PutBlock(connString, container, blobName, block.id, block.data); err == nil {
+						break
+					}
+				}
+				if err != nil {
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = errors.New("block " + block.id + ": " + err.Error())
+					}
+					errLock.Unlock()
+				}
+			}
+		}()
+	}
+
+	var blockIDs []string
+	var readErr error
+	for !failed() {
+		data := make([]byte, blockSize)
+		n, err := io.ReadFull(r, data)
+		if n > 0 {
+			if len(blockIDs) == MaxBlocks {
+				readErr = errors.New("blob needs more than MaxBlocks blocks, use a larger block size")
+				break
+			}
+			// the block ID holds the MD5 of the block, so a block staged from
+			// a source that has changed since is not reused
+			sum := md5.Sum(data[:n])
+			id := base64.StdEncoding.EncodeToString(append([]byte(fmt.Sprintf("%s-%08d-", uploadID, len(blockIDs))), sum[:]...))
+			blockIDs = append(blockIDs, id)
+			if size, ok := staged[id]; !ok || size != int64(n) {
+				blocks <- uploadBlock{id: id, data: data[:n]}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	close(blocks)
+	wg.Wait()
+
+	if readErr != nil {
+		return readErr
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return PutBlockList(connString, container, blobName, blockIDs, opts.Headers, opts.Metadata)
+}
+
+// PutBlock stages one block of a block blob. blockID must be base64 and
+// the same length as the blob's other block IDs.
+func PutBlock(connString string, container string, blobName string, blockID string, data []byte) error {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(data)
+	header := map[string][]string{headerContentMD5: {base64.StdEncoding.EncodeToString(sum[:])}}
+	URI := credential.BlobURL(container+"/"+blobName, "comp=block&blockid="+url.QueryEscape(blockID))
+	resp, err := credential.HttpStreamRequest("PUT", URI, data, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return newStorageError(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PutBlockList commits the blob from the given staged blocks, in order.
+func PutBlockList(connString string, container string, blobName string, blockIDs []string, headers BlobHTTPHeaders, metadata map[string]string) error {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	body.WriteString(xml.Header + "<BlockList>")
+	for _, id := range blockIDs {
+		body.WriteString("<Latest>" + id + "</Latest>")
+	}
+	body.WriteString("</BlockList>")
+
+	header := make(map[string][]string)
+	httpHeadersHeader(headers, header)
+	metadataHeader(metadata, header)
+
+	resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(container+"/"+blobName, "comp=blocklist"), body.Bytes(), header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return newStorageError(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetBlockList returns the blob's blocks; listType is "committed",
+// "uncommitted" or "all".
+func GetBlockList(connString string, container string, blobName string, listType string) (*BlockList, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL(container+"/"+blobName, "comp=blocklist&blocklisttype="+listType), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var list BlockList
+	if err := xml.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
@@ -0,0 +1,63 @@
+package utils_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"main/utils"
+	"main/utils/storagetest"
+	"testing"
+)
+
+// failingReader returns the data and then err instead of io.EOF.
+type failingReader struct {
+	data *bytes.Reader
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.data.Len() == 0 {
+		return 0, f.err
+	}
+	return f.data.Read(p)
+}
+
+func TestUploadBlobResume(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+
+	if _, err := utils.CreateContainerIfNotExists(connString, "uploads"); err != nil {
+		t.Fatal(err)
+	}
+	opts := utils.UploadOptions{BlockSize: 1024, UploadID: "resume"}
+
+	// the first run stages two blocks and fails before the commit
+	old := bytes.Repeat([]byte("a"), 3*1024)
+	interrupted := &failingReader{data: bytes.NewReader(old[:2*1024]), err: errors.New("disk gone")}
+	if err := utils.UploadBlob(connString, "uploads", "data.bin", interrupted, opts); err == nil {
+		t.Fatal("interrupted upload succeeded")
+	}
+	list, err := utils.GetBlockList(connString, "uploads", "data.bin", "uncommitted")
+	if err != nil || len(list.UncommittedBlocks) != 2 {
+		t.Fatalf("staged blocks: %v %v", list, err)
+	}
+
+	// the source changed without changing size before the upload is resumed
+	data := append(bytes.Repeat([]byte("a"), 1024), bytes.Repeat([]byte("b"), 2*1024)...)
+	if err := utils.UploadBlob(connString, "uploads", "data.bin", bytes.NewReader(data), opts); err != nil {
+		t.Fatal(err)
+	}
+	get := utils.GetBlob(connString, "uploads", "data.bin")
+	if get.Error != nil || !bytes.Equal(get.ResponseBody, data) {
+		t.Fatalf("uploaded content differs: %v", get.Error)
+	}
+	list, err = utils.GetBlockList(connString, "uploads", "data.bin", "committed")
+	if err != nil || len(list.CommittedBlocks) != 3 {
+		t.Fatalf("committed blocks: %v %v", list, err)
+	}
+
+	if err := utils.UploadBlob(connString, "uploads", "long.bin", io.LimitReader(bytes.NewReader(data), 10), utils.UploadOptions{UploadID: string(bytes.Repeat([]byte("x"), 39))}); err == nil {
+		t.Fatal("accepted an upload id longer than 38 bytes")
+	}
+}
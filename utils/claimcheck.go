@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ClaimCheck points at a blob holding a message body that was too large to
+// be sent through the queue. The blob lives in the same storage account as
+// the queue.
+type ClaimCheck struct {
+	Container string `json:"container"`
+	BlobName  string `json:"blobName"`
+	Size      int    `json:"size"`
+}
+
+// ErrClaimCheckBackend is returned when a claim-check blob is needed on the
+// memory: or file: backend, which have no blob store.
+var ErrClaimCheckBackend = errors.New("claim-check requires an Azure connection string; the memory: and file: backends cannot store blobs")
+
+func putClaimCheck(connString string, container string, queueName string, body string) (*ClaimCheck, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	blobName := queueName + "/" + time.Now().UTC().Format("20060102") + "/" + hex.EncodeToString(id)
+
+	opts := PutBlobOptions{
+		Headers:     BlobHTTPHeaders{ContentType: "text/plain; charset=utf-8"},
+		IfNoneMatch: "*",
+	}
+	post := PutBlob(connString, container, blobName, body, opts)
+	if post.Error == nil && post.StatusCode == 404 {
+		if _, err := CreateContainerIfNotExists(connString, container); err != nil {
+			return nil, err
+		}
+		post = PutBlob(connString, container, blobName, body, opts)
+	}
+	if post.Error != nil {
+		return nil, post.Error
+	}
+	if post.StatusCode != 201 {
+		return nil, errors.New("claim check upload failed: " + strconv.Itoa(post.StatusCode) + " " + string(post.ResponseBody))
+	}
+	return &ClaimCheck{Container: container, BlobName: blobName, Size: len(body)}, nil
+}
+
+func getClaimCheck(connString string, claimCheck *ClaimCheck) (string, error) {
+	if IsLocalBackend(connString) {
+		return "", ErrClaimCheckBackend
+	}
+	get := GetBlob(connString, claimCheck.Container, claimCheck.BlobName)
+	if get.Error != nil {
+		return "", get.Error
+	}
+	if get.StatusCode != 200 {
+		return "", errors.New("claim check download failed: " + strconv.Itoa(get.StatusCode) + " " + claimCheck.Container + "/" + claimCheck.BlobName)
+	}
+	return string(get.ResponseBody), nil
+}
+
+// DeleteClaimCheck removes the blob behind a claim-checked message. It is a
+// no-op for messages that were sent inline.
+func DeleteClaimCheck(connString string, msg *QueueMessage) error {
+	if msg == nil || msg.Envelope == nil || msg.Envelope.ClaimCheck == nil {
+		return nil
+	}
+	if IsLocalBackend(connString) {
+		return ErrClaimCheckBackend
+	}
+	claimCheck := msg.Envelope.ClaimCheck
+	delete := DeleteBlob(connString, claimCheck.Container, claimCheck.BlobName)
+	if delete.Error != nil {
+		return delete.Error
+	}
+	if delete.StatusCode != 202 && delete.StatusCode != 404 {
+		return errors.New("claim check delete failed: " + strconv.Itoa(delete.StatusCode))
+	}
+	return nil
+}
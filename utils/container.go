@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ContainerProperties are the system properties and metadata of a container.
+type ContainerProperties struct {
+	ETag         string
+	LastModified time.Time
+	LeaseStatus  string
+	LeaseState   string
+	Metadata     map[string]string
+}
+
+type ListContainersOptions struct {
+	Prefix string
+	// Marker continues a listing from the NextMarker of a previous page.
+	Marker string
+	// MaxResults defaults to the service's 5000.
+	MaxResults      int
+	IncludeMetadata bool
+}
+
+type ContainerItem struct {
+	Name       string
+	Properties *ContainerProperties
+}
+
+// ListContainersResult is one page of a container listing. More pages
+// follow while NextMarker is not empty.
+type ListContainersResult struct {
+	Containers []ContainerItem
+	NextMarker string
+}
+
+type listContainersXML struct {
+	XMLName    xml.Name `xml:"EnumerationResults"`
+	Containers []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			LastModified string `xml:"Last-Modified"`
+			ETag         string `xml:"Etag"`
+			LeaseStatus  string `xml:"LeaseStatus"`
+			LeaseState   string `xml:"LeaseState"`
+		} `xml:"Properties"`
+		Metadata blobMetadataXML `xml:"Metadata"`
+	} `xml:"Containers>Container"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// CreateContainer creates a private container. It returns a 409
+// StorageError if the container already exists.
+func CreateContainer(connString string, container string, metadata map[string]string) error {
+	header := make(map[string][]string)
+	metadataHeader(metadata, header)
+	return containerRequest(connString, "PUT", container, "", header, http.StatusCreated)
+}
+
+// CreateContainerIfNotExists creates container unless it exists already and
+// reports whether it was created.
+func CreateContainerIfNotExists(connString string, container string) (bool, error) {
+	err := CreateContainer(connString, container, nil)
+	if ErrorStatus(err) == http.StatusConflict {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteContainer marks container and every blob in it for deletion.
+func DeleteContainer(connString string, container string) error {
+	return containerRequest(connString, "DELETE", container, "", nil, http.StatusAccepted)
+}
+
+func GetContainerProperties(connString string, container string) (*ContainerProperties, error) {
+	resp, err := containerResponse(connString, "GET", container, "", nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return containerPropertiesFromHeader(resp.Header), nil
+}
+
+func GetContainerMetadata(connString string, container string) (map[string]string, error) {
+	resp, err := containerResponse(connString, "GET", container, "metadata", nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return metadataFromHeader(resp.Header), nil
+}
+
+// SetContainerMetadata replaces all metadata of container.
+func SetContainerMetadata(connString string, container string, metadata map[string]string) error {
+	header := make(map[string][]string)
+	metadataHeader(metadata, header)
+	return containerRequest(connString, "PUT", container, "metadata", header, http.StatusOK)
+}
+
+// ListContainers returns one page of the containers in the account.
+func ListContainers(connString string, opts ListContainersOptions) (*ListContainersResult, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("comp", "list")
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.Marker != "" {
+		query.Set("marker", opts.Marker)
+	}
+	if opts.MaxResults > 0 {
+		query.Set("maxresults", strconv.Itoa(opts.MaxResults))
+	}
+	if opts.IncludeMetadata {
+		query.Set("include", "metadata")
+	}
+
+	resp, err := credential.HttpStreamRequest("GET", credential.BlobURL("", query.Encode()), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(resp)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var list listContainersXML
+	if err := xml.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	result := &ListContainersResult{NextMarker: list.NextMarker}
+	for _, c := range list.Containers {
+		props := &ContainerProperties{
+			ETag:        c.Properties.ETag,
+			LeaseStatus: c.Properties.LeaseStatus,
+			LeaseState:  c.Properties.LeaseState,
+			Metadata:    map[string]string(c.Metadata),
+		}
+		props.LastModified, _ = time.Parse(http.TimeFormat, c.Properties.LastModified)
+		result.Containers = append(result.Containers, ContainerItem{Name: c.Name, Properties: props})
+	}
+	return result, nil
+}
+
+// ListAllContainers follows NextMarker until every matching container has
+// been listed.
+func ListAllContainers(connString string, opts ListContainersOptions) ([]ContainerItem, error) {
+	var containers []ContainerItem
+	for {
+		page, err := ListContainers(connString, opts)
+		if err != nil {
+			return containers, err
+		}
+		containers = append(containers, page.Containers...)
+		if page.NextMarker == "" {
+			return containers, nil
+		}
+		opts.Marker = page.NextMarker
+	}
+}
+
+func containerPropertiesFromHeader(header http.Header) *ContainerProperties {
+	props := &ContainerProperties{
+		ETag:        header.Get("ETag"),
+		LeaseStatus: header.Get("x-ms-lease-status"),
+		LeaseState:  header.Get("x-ms-lease-state"),
+		Metadata:    metadataFromHeader(header),
+	}
+	props.LastModified, _ = time.Parse(http.TimeFormat, header.Get("Last-Modified"))
+	return props
+}
+
+func containerRequest(connString string, method string, container string, comp string, header map[string][]string, expected int) error {
+	_, err := containerResponse(connString, method, container, comp, header, expected)
+	return err
+}
+
+// containerResponse sends a ?restype=container request and returns the
+// response with its body closed.
+func containerResponse(connString string, method string, container string, comp string, header map[string][]string, expected int) (*http.Response, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	URI := credential.BlobURL(container, "restype=container")
+	if comp != "" {
+		URI += "&comp=" + comp
+	}
+	resp, err := credential.HttpStreamRequest(method, URI, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		return nil, newStorageError(resp)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// envelopeVersion marks a message text as an Envelope rather than a plain payload.
+const envelopeVersion = "aqp/1"
+
+// Envelope wraps a queue message payload that was transformed before it was
+// sent, so consumers can detect the transformation and reverse it. When
+// Codec or KeyID is set, Body holds the compressed and then encrypted
+// payload in base64.
+type Envelope struct {
+	Version    string      `json:"aqpEnvelope"`
+	Codec      string      `json:"codec,omitempty"`
+	KeyID      string      `json:"keyId,omitempty"`
+	ClaimCheck *ClaimCheck `json:"claimCheck,omitempty"`
+	Attempt    int         `json:"attempt,omitempty"`
+	// JobID is the ID of the message that first carried the job; retries
+	// are new messages with new IDs.
+	JobID string `json:"jobId,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+func NewEnvelope() *Envelope {
+	return &Envelope{Version: envelopeVersion}
+}
+
+func (f *Envelope) String() string {
+	s, _ := json.Marshal(f)
+	return string(s)
+}
+
+// ParseEnvelope returns the Envelope held in messageText, or nil when
+// messageText is a plain payload.
+func ParseEnvelope(messageText string) *Envelope {
+	if !strings.HasPrefix(strings.TrimSpace(messageText), "{") {
+		return nil
+	}
+	var env Envelope
+	if err := json.Unmarshal([]byte(messageText), &env); err != nil {
+		return nil
+	}
+	if env.Version != envelopeVersion {
+		return nil
+	}
+	return &env
+}
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileQueueStaleLock is how old a lock file must be before it is assumed
+// to belong to a crashed process and is removed.
+const fileQueueStaleLock = 30 * time.Second
+
+// FileQueue is a durable Queue stored under a local directory, one JSON
+// file per message. A lock file serialises access, so several processes on
+// one machine can share a queue.
+type FileQueue struct {
+	dir string
+}
+
+func NewFileQueue(root string, queueName string) *FileQueue {
+	return &FileQueue{dir: filepath.Join(root, queueName)}
+}
+
+func (f *FileQueue) Send(messageText string, visibilityTimeout time.Duration) (string, error) {
+	msg := newStoredMessage(messageText, visibilityTimeout)
+	err := f.withLock(func() error {
+		return f.write(msg)
+	})
+	if err != nil {
+		return "", err
+	}
+	return msg.MessageId, nil
+}
+
+func (f *FileQueue) Receive(count int, visibilityTimeout time.Duration) ([]QueueMessage, error) {
+	var messages []QueueMessage
+	err := f.withLock(func() error {
+		stored, err := f.readAll()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, msg := range stored {
+			if len(messages) == count {
+				break
+			}
+			if msg.visible(now) {
+				msg.receive(now, visibilityTimeout)
+				if err := f.write(msg); err != nil {
+					return err
+				}
+				messages = append(messages, msg.queueMessage(true))
+			}
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (f *FileQueue) Delete(messageId string, popReceipt string) error {
+	return f.withLock(func() error {
+		msg, err := f.read(messageId)
+		if err != nil {
+			return err
+		}
+		if msg.PopReceipt != popReceipt {
+			return ErrMessageNotFound
+		}
+		return os.Remove(f.path(messageId))
+	})
+}
+
+func (f *FileQueue) Update(messageId string, popReceipt string, messageText string, visibilityTimeout time.Duration) (string, error) {
+	var newPopReceipt string
+	err := f.withLock(func() error {
+		msg, err := f.read(messageId)
+		if err != nil {
+			return err
+		}
+		if msg.PopReceipt != popReceipt {
+			return ErrMessageNotFound
+		}
+		msg.MessageText = messageText
+		msg.PopReceipt = randomHex(16)
+		msg.NextVisible = time.Now().UTC().Add(visibilityTimeout)
+		newPopReceipt = msg.PopReceipt
+		return f.write(msg)
+	})
+	return newPopReceipt, err
+}
+
+func (f *FileQueue) Peek(count int) ([]QueueMessage, error) {
+	var messages []QueueMessage
+	err := f.withLock(func() error {
+		stored, err := f.readAll()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, msg := range stored {
+			if len(messages) == count {
+				break
+			}
+			if msg.visible(now) {
+				messages = append(messages, msg.queueMessage(false))
+			}
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (f *FileQueue) Count() (int, error) {
+	count := 0
+	err := f.withLock(func() error {
+		stored, err := f.readAll()
+		count = len(stored)
+		return err
+	})
+	return count, err
+}
+
+func (f *FileQueue) Clear() error {
+	return f.withLock(func() error {
+		files, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (f *FileQueue) path(messageId string) string {
+	return filepath.Join(f.dir, messageId+".json")
+}
+
+func (f *FileQueue) read(messageId string) (*storedMessage, error) {
+	if strings.ContainsAny(messageId, `/\`) {
+		return nil, ErrMessageNotFound
+	}
+	content, err := ioutil.ReadFile(f.path(messageId))
+	if os.IsNotExist(err) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msg storedMessage
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// readAll returns the queue's messages in insertion order, deleting any
+// that have expired.
+func (f *FileQueue) readAll() ([]*storedMessage, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	now := time.Now().UTC()
+	var messages []*storedMessage
+	for _, file := range files {
+		msg, err := f.read(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if msg.expired(now) {
+			_ = os.Remove(file)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (f *FileQueue) write(msg *storedMessage) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	tmp := f.path(msg.MessageId) + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(msg.MessageId))
+}
+
+func (f *FileQueue) withLock(fn func() error) error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	lockPath := filepath.Join(f.dir, ".lock")
+	deadline := time.Now().Add(2 * fileQueueStaleLock)
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			lock.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > fileQueueStaleLock {
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for queue lock " + lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer os.Remove(lockPath)
+	return fn()
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MessageKeyring holds the keys used to encrypt and decrypt queue messages.
+// Messages are sent in plaintext and encrypted messages cannot be read
+// while it is nil.
+var MessageKeyring *Keyring
+
+// Keyring is a set of AES keys identified by key ID. New messages are
+// encrypted with the primary key; any key in the ring can decrypt, which
+// lets old messages drain while keys are rotated.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// UnknownKeyError is returned when a message is encrypted with a key the
+// keyring does not hold, as happens while a new key is being rolled out.
+// The message itself may be intact.
+type UnknownKeyError struct {
+	KeyID string
+}
+
+func (f *UnknownKeyError) Error() string {
+	return "unknown key id: " + f.KeyID
+}
+
+// IsUnknownKey reports whether err is an UnknownKeyError.
+func IsUnknownKey(err error) bool {
+	_, ok := err.(*UnknownKeyError)
+	return ok
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// Add registers an AES-128, AES-192 or AES-256 key. The first key added
+// becomes the primary key.
+func (f *Keyring) Add(keyID string, key []byte) error {
+	if keyID == "" {
+		return errors.New("key id must not be empty")
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return errors.New("key " + keyID + ": " + err.Error())
+	}
+	f.keys[keyID] = key
+	if f.primary == "" {
+		f.primary = keyID
+	}
+	return nil
+}
+
+func (f *Keyring) SetPrimary(keyID string) error {
+	if _, ok := f.keys[keyID]; !ok {
+		return &UnknownKeyError{KeyID: keyID}
+	}
+	f.primary = keyID
+	return nil
+}
+
+func (f *Keyring) Primary() string {
+	return f.primary
+}
+
+// Encrypt seals data with the primary key using AES-GCM. The returned
+// ciphertext is prefixed with its nonce.
+func (f *Keyring) Encrypt(data []byte) (keyID string, ciphertext []byte, err error) {
+	if f.primary == "" {
+		return "", nil, errors.New("keyring has no primary key")
+	}
+	gcm, err := f.gcm(f.primary)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return f.primary, gcm.Seal(nonce, nonce, data, []byte(f.primary)), nil
+}
+
+func (f *Keyring) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	gcm, err := f.gcm(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], []byte(keyID))
+}
+
+func (f *Keyring) gcm(keyID string) (cipher.AEAD, error) {
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, &UnknownKeyError{KeyID: keyID}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ParseKeyring reads keys in the form "id1:base64key1,id2:base64key2".
+// The first key is the primary key.
+func ParseKeyring(spec string) (*Keyring, error) {
+	keyring := NewKeyring()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid key entry, expected id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.New("key " + parts[0] + ": " + err.Error())
+		}
+		if err := keyring.Add(parts[0], key); err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+// LoadKeyringDir reads every *.key file in dir as a base64 key named after
+// the file. The primary key is the one named in a "primary" file, or the
+// last key ID in sort order when there is none.
+func LoadKeyringDir(dir string) (*Keyring, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	keyring := NewKeyring()
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		keyID := strings.TrimSuffix(filepath.Base(file), ".key")
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil, errors.New("key " + keyID + ": " + err.Error())
+		}
+		if err := keyring.Add(keyID, key); err != nil {
+			return nil, err
+		}
+		keyring.primary = keyID
+	}
+
+	if primary, err := ioutil.ReadFile(filepath.Join(dir, "primary")); err == nil {
+		if err := keyring.SetPrimary(strings.TrimSpace(string(primary))); err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+// LoadKeyringFromEnv builds a keyring from MESSAGE_KEYS (see ParseKeyring)
+// or, failing that, from the directory in MESSAGE_KEYS_DIR. It returns nil
+// when neither is set.
+func LoadKeyringFromEnv() (*Keyring, error) {
+	if spec := os.Getenv("MESSAGE_KEYS"); spec != "" {
+		return ParseKeyring(spec)
+	}
+	if dir := os.Getenv("MESSAGE_KEYS_DIR"); dir != "" {
+		return LoadKeyringDir(dir)
+	}
+	return nil, nil
+}
@@ -0,0 +1,163 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"main/utils"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestParseKeyring(t *testing.T) {
+	keyring, err := utils.ParseKeyring(" a:" + testKey(1) + ", b:" + testKey(2) + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Primary() != "a" {
+		t.Fatalf("primary %q", keyring.Primary())
+	}
+
+	for _, spec := range []string{
+		"a" + testKey(1),
+		"a:not base64!",
+		"a:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		":" + testKey(1),
+	} {
+		if _, err := utils.ParseKeyring(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
+
+func TestLoadKeyringDir(t *testing.T) {
+	dir := t.TempDir()
+	for id, b := range map[string]byte{"2026-01": 1, "2026-02": 2} {
+		if err := ioutil.WriteFile(filepath.Join(dir, id+".key"), []byte(testKey(b)+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without a primary file the last key in sort order is primary
+	keyring, err := utils.LoadKeyringDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Primary() != "2026-02" {
+		t.Fatalf("primary %q", keyring.Primary())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "primary"), []byte("2026-01\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyring, err = utils.LoadKeyringDir(dir); err != nil || keyring.Primary() != "2026-01" {
+		t.Fatalf("primary file: %v %v", keyring, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "primary"), []byte("2025-12"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.LoadKeyringDir(dir); !utils.IsUnknownKey(err) {
+		t.Fatalf("unknown primary: %v", err)
+	}
+}
+
+func TestLoadKeyringFromEnv(t *testing.T) {
+	t.Setenv("MESSAGE_KEYS", "")
+	t.Setenv("MESSAGE_KEYS_DIR", "")
+	if keyring, err := utils.LoadKeyringFromEnv(); keyring != nil || err != nil {
+		t.Fatalf("unset: %v %v", keyring, err)
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "d.key"), []byte(testKey(4)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MESSAGE_KEYS_DIR", dir)
+	if keyring, err := utils.LoadKeyringFromEnv(); err != nil || keyring.Primary() != "d" {
+		t.Fatalf("MESSAGE_KEYS_DIR: %v %v", keyring, err)
+	}
+
+	// MESSAGE_KEYS takes precedence
+	t.Setenv("MESSAGE_KEYS", "e:"+testKey(5))
+	if keyring, err := utils.LoadKeyringFromEnv(); err != nil || keyring.Primary() != "e" {
+		t.Fatalf("MESSAGE_KEYS: %v %v", keyring, err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	defer func(keyring *utils.Keyring) { utils.MessageKeyring = keyring }(utils.MessageKeyring)
+	keyring, err := utils.ParseKeyring("a:" + testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.MessageKeyring = keyring
+
+	old, err := utils.SealMessage("memory:", "rotation", "sealed under a", utils.MessageOptions{Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// roll out b, then make it primary; messages sealed under a still open
+	if err := keyring.Add("b", bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetPrimary("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetPrimary("c"); !utils.IsUnknownKey(err) {
+		t.Fatalf("SetPrimary of an unknown key: %v", err)
+	}
+	current, err := utils.SealMessage("memory:", "rotation", "sealed under b", utils.MessageOptions{Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]string{old: "sealed under a", current: "sealed under b"} {
+		body, env, err := utils.OpenMessage("memory:", text)
+		if err != nil || body != want {
+			t.Fatalf("open %q: %q %v", want, body, err)
+		}
+		if want == "sealed under b" && env.KeyID != "b" {
+			t.Fatalf("sealed with key %q", env.KeyID)
+		}
+	}
+}
+
+func TestUnknownKey(t *testing.T) {
+	defer func(keyring *utils.Keyring) { utils.MessageKeyring = keyring }(utils.MessageKeyring)
+	sender, err := utils.ParseKeyring("new:" + testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.MessageKeyring = sender
+	text, err := utils.SealMessage("memory:", "unknown", "secret", utils.MessageOptions{Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a receiver that has not loaded the new key yet, or no keyring at all
+	receiver, err := utils.ParseKeyring("old:" + testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keyring := range []*utils.Keyring{receiver, nil} {
+		utils.MessageKeyring = keyring
+		if _, _, err := utils.OpenMessage("memory:", text); !utils.IsUnknownKey(err) {
+			t.Fatalf("unknown key: %v", err)
+		}
+	}
+
+	// corrupt data under a known key is a different error
+	ciphertext, err := base64.StdEncoding.DecodeString(utils.ParseEnvelope(text).Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := sender.Decrypt("new", ciphertext); err == nil || utils.IsUnknownKey(err) {
+		t.Fatalf("corrupt ciphertext: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// InfiniteLease is the lease duration for a lease that never expires.
+const InfiniteLease = -1
+
+// AcquireBlobLease takes a lease of duration seconds (15 to 60, or
+// InfiniteLease) on the blob and returns its lease ID. proposedLeaseID may
+// be empty to let the service choose one.
+func AcquireBlobLease(connString string, container string, blobName string, duration int, proposedLeaseID string) (string, error) {
+	header := map[string][]string{
+		"x-ms-lease-action":   {"acquire"},
+		"x-ms-lease-duration": {strconv.Itoa(duration)},
+	}
+	if proposedLeaseID != "" {
+		header["x-ms-proposed-lease-id"] = []string{proposedLeaseID}
+	}
+	resp, err := blobLeaseRequest(connString, container, blobName, header, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	return resp.Header.Get("x-ms-lease-id"), nil
+}
+
+func RenewBlobLease(connString string, container string, blobName string, leaseID string) error {
+	header := map[string][]string{
+		"x-ms-lease-action": {"renew"},
+		"x-ms-lease-id":     {leaseID},
+	}
+	_, err := blobLeaseRequest(connString, container, blobName, header, http.StatusOK)
+	return err
+}
+
+func ReleaseBlobLease(connString string, container string, blobName string, leaseID string) error {
+	header := map[string][]string{
+		"x-ms-lease-action": {"release"},
+		"x-ms-lease-id":     {leaseID},
+	}
+	_, err := blobLeaseRequest(connString, container, blobName, header, http.StatusOK)
+	return err
+}
+
+// ChangeBlobLease swaps the ID of an active lease and returns the new ID.
+func ChangeBlobLease(connString string, container string, blobName string, leaseID string, proposedLeaseID string) (string, error) {
+	header := map[string][]string{
+		"x-ms-lease-action":      {"change"},
+		"x-ms-lease-id":          {leaseID},
+		"x-ms-proposed-lease-id": {proposedLeaseID},
+	}
+	resp, err := blobLeaseRequest(connString, container, blobName, header, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	return resp.Header.Get("x-ms-lease-id"), nil
+}
+
+// BreakBlobLease ends the current lease after breakPeriod seconds, or when
+// it would expire anyway if breakPeriod is negative. It returns the seconds
+// left until the lease is broken.
+func BreakBlobLease(connString string, container string, blobName string, breakPeriod int) (int, error) {
+	header := map[string][]string{"x-ms-lease-action": {"break"}}
+	if breakPeriod >= 0 {
+		header["x-ms-lease-break-period"] = []string{strconv.Itoa(breakPeriod)}
+	}
+	resp, err := blobLeaseRequest(connString, container, blobName, header, http.StatusAccepted)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(resp.Header.Get("x-ms-lease-time"))
+}
+
+func blobLeaseRequest(connString string, container string, blobName string, header map[string][]string, expected int) (*http.Response, error) {
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := credential.HttpStreamRequest("PUT", credential.BlobURL(container+"/"+blobName, "comp=lease"), nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		return nil, newStorageError(resp)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMessageNotFound is returned by the memory and file backends when a
+// message ID or pop receipt does not match a message in the queue.
+var ErrMessageNotFound = errors.New("message not found or pop receipt does not match")
+
+// MemoryQueue is a Queue held in process memory, for tests and local runs.
+type MemoryQueue struct {
+	lock     sync.Mutex
+	messages []*storedMessage
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+func (f *MemoryQueue) Send(messageText string, visibilityTimeout time.Duration) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	msg := newStoredMessage(messageText, visibilityTimeout)
+	f.messages = append(f.messages, msg)
+	return msg.MessageId, nil
+}
+
+func (f *MemoryQueue) Receive(count int, visibilityTimeout time.Duration) ([]QueueMessage, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.removeExpired()
+
+	now := time.Now().UTC()
+	var messages []QueueMessage
+	for _, msg := range f.messages {
+		if len(messages) == count {
+			break
+		}
+		if msg.visible(now) {
+			msg.receive(now, visibilityTimeout)
+			messages = append(messages, msg.queueMessage(true))
+		}
+	}
+	return messages, nil
+}
+
+func (f *MemoryQueue) Delete(messageId string, popReceipt string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, msg := range f.messages {
+		if msg.MessageId == messageId && msg.PopReceipt == popReceipt {
+			f.messages = append(f.messages[:i], f.messages[i+1:]...)
+			return nil
+		}
+	}
+	return ErrMessageNotFound
+}
+
+func (f *MemoryQueue) Update(messageId string, popReceipt string, messageText string, visibilityTimeout time.Duration) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, msg := range f.messages {
+		if msg.MessageId == messageId && msg.PopReceipt == popReceipt {
+			msg.MessageText = messageText
+			msg.PopReceipt = randomHex(16)
+			msg.NextVisible = time.Now().UTC().Add(visibilityTimeout)
+			return msg.PopReceipt, nil
+		}
+	}
+	return "", ErrMessageNotFound
+}
+
+// Message returns the message with messageId, including its pop receipt,
+// whether or not it is visible.
+func (f *MemoryQueue) Message(messageId string) (QueueMessage, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, msg := range f.messages {
+		if msg.MessageId == messageId {
+			return msg.queueMessage(true), true
+		}
+	}
+	return QueueMessage{}, false
+}
+
+func (f *MemoryQueue) Peek(count int) ([]QueueMessage, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.removeExpired()
+
+	now := time.Now().UTC()
+	var messages []QueueMessage
+	for _, msg := range f.messages {
+		if len(messages) == count {
+			break
+		}
+		if msg.visible(now) {
+			messages = append(messages, msg.queueMessage(false))
+		}
+	}
+	return messages, nil
+}
+
+func (f *MemoryQueue) Count() (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.removeExpired()
+	return len(f.messages), nil
+}
+
+func (f *MemoryQueue) Clear() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.messages = nil
+	return nil
+}
+
+func (f *MemoryQueue) removeExpired() {
+	now := time.Now().UTC()
+	kept := f.messages[:0]
+	for _, msg := range f.messages {
+		if !msg.expired(now) {
+			kept = append(kept, msg)
+		}
+	}
+	f.messages = kept
+}
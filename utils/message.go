@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"time"
+)
+
+// MaxQueueMessageSize is the largest message text the queue service accepts.
+const MaxQueueMessageSize = 64 * 1024
+
+// MessageOptions controls how PostQueue prepares a message before sending it.
+type MessageOptions struct {
+	// ClaimCheckContainer is the blob container that receives payloads too
+	// large for the queue. Claim-check is disabled when it is empty and
+	// fails with ErrClaimCheckBackend on the memory: and file: backends.
+	ClaimCheckContainer string
+	// ClaimCheckThreshold is the message size, in bytes, above which the
+	// payload is moved to a blob. Defaults to MaxQueueMessageSize.
+	ClaimCheckThreshold int
+	// Codec compresses the payload, e.g. CodecGzip. Messages are sent
+	// uncompressed when it is empty.
+	Codec string
+	// Encrypt seals the payload with the primary key of MessageKeyring.
+	Encrypt bool
+	// VisibilityTimeout hides the message from consumers for this long
+	// after it is sent.
+	VisibilityTimeout time.Duration
+	// Attempt and JobID are recorded in the envelope of a retried job.
+	Attempt int
+	JobID   string
+}
+
+// QueueMessage is a single message received from a queue.
+type QueueMessage struct {
+	MessageId       string
+	InsertionTime   string
+	ExpirationTime  string
+	PopReceipt      string `xml:",omitempty"`
+	TimeNextVisible string `xml:",omitempty"`
+	DequeueCount    int
+	MessageText     string
+
+	// Body is the payload after any envelope has been unwrapped.
+	Body     string    `xml:"-"`
+	Envelope *Envelope `xml:"-"`
+}
+
+// Attempt returns how many times the message has already been retried.
+func (f *QueueMessage) Attempt() int {
+	if f.Envelope == nil {
+		return 0
+	}
+	return f.Envelope.Attempt
+}
+
+// JobID identifies the job across retries: the ID of the message that
+// first carried it.
+func (f *QueueMessage) JobID() string {
+	if f.Envelope != nil && f.Envelope.JobID != "" {
+		return f.Envelope.JobID
+	}
+	return f.MessageId
+}
+
+type queueMessagesList struct {
+	XMLName  xml.Name       `xml:"QueueMessagesList"`
+	Messages []QueueMessage `xml:"QueueMessage"`
+}
+
+// SealMessage turns message into the text that is sent to the queue,
+// wrapping it in an Envelope when one of the options requires it.
+func SealMessage(connString string, queueName string, message string, opts MessageOptions) (string, error) {
+	if opts.ClaimCheckContainer != "" && IsLocalBackend(connString) {
+		return "", ErrClaimCheckBackend
+	}
+	env := NewEnvelope()
+	text := message
+	data := []byte(message)
+	var err error
+
+	if opts.Codec != "" {
+		data, err = compressBody(opts.Codec, data)
+		if err != nil {
+			return "", err
+		}
+		env.Codec = opts.Codec
+	}
+	if opts.Encrypt {
+		if MessageKeyring == nil {
+			return "", errors.New("message encryption requested but no keyring is loaded")
+		}
+		env.KeyID, data, err = MessageKeyring.Encrypt(data)
+		if err != nil {
+			return "", err
+		}
+	}
+	env.Attempt, env.JobID = opts.Attempt, opts.JobID
+	if env.Codec != "" || env.KeyID != "" {
+		env.Body = base64.StdEncoding.EncodeToString(data)
+		text = env.String()
+	} else if env.Attempt > 0 || env.JobID != "" {
+		env.Body = message
+		text = env.String()
+	}
+
+	threshold := opts.ClaimCheckThreshold
+	if threshold <= 0 {
+		threshold = MaxQueueMessageSize
+	}
+	if opts.ClaimCheckContainer == "" || len(escapeMessageText(text)) <= threshold {
+		return text, nil
+	}
+
+	body := message
+	if env.Body != "" {
+		body = env.Body
+	}
+	claimCheck, err := putClaimCheck(connString, opts.ClaimCheckContainer, queueName, body)
+	if err != nil {
+		return "", err
+	}
+	env.ClaimCheck = claimCheck
+	env.Body = ""
+	return env.String(), nil
+}
+
+// OpenMessage reverses SealMessage, returning the original payload and the
+// envelope it arrived in. Plain messages are returned unchanged with a nil envelope.
+func OpenMessage(connString string, messageText string) (body string, env *Envelope, err error) {
+	env = ParseEnvelope(messageText)
+	if env == nil {
+		return messageText, nil, nil
+	}
+
+	body = env.Body
+	if env.ClaimCheck != nil {
+		body, err = getClaimCheck(connString, env.ClaimCheck)
+		if err != nil {
+			return "", env, err
+		}
+	}
+	if env.Codec == "" && env.KeyID == "" {
+		return body, env, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", env, err
+	}
+	if env.KeyID != "" {
+		if MessageKeyring == nil {
+			return "", env, &UnknownKeyError{KeyID: env.KeyID}
+		}
+		data, err = MessageKeyring.Decrypt(env.KeyID, data)
+		if err != nil {
+			return "", env, err
+		}
+	}
+	if env.Codec != "" {
+		data, err = decompressBody(env.Codec, data)
+		if err != nil {
+			return "", env, err
+		}
+	}
+	return string(data), env, nil
+}
+
+// readQueueMessages reads a QueueMessagesList response and detects the
+// envelope of every message without unwrapping it.
+func readQueueMessages(responseBody []byte) ([]QueueMessage, error) {
+	var list queueMessagesList
+	if err := xml.Unmarshal(responseBody, &list); err != nil {
+		return nil, err
+	}
+	for i := range list.Messages {
+		list.Messages[i].Envelope = ParseEnvelope(list.Messages[i].MessageText)
+	}
+	return list.Messages, nil
+}
+
+// parseQueueMessages reads a QueueMessagesList response and unwraps the
+// envelope of every message in it.
+func parseQueueMessages(connString string, responseBody []byte) ([]QueueMessage, error) {
+	var list queueMessagesList
+	if err := xml.Unmarshal(responseBody, &list); err != nil {
+		return nil, err
+	}
+	for i := range list.Messages {
+		msg := &list.Messages[i]
+		var err error
+		msg.Body, msg.Envelope, err = OpenMessage(connString, msg.MessageText)
+		if err != nil {
+			return list.Messages, err
+		}
+	}
+	return list.Messages, nil
+}
+
+// openQueueMessagesXML rewrites a QueueMessagesList response so that every
+// MessageText holds the unwrapped payload.
+func openQueueMessagesXML(connString string, responseBody []byte) []byte {
+	messages, err := parseQueueMessages(connString, responseBody)
+	if err != nil {
+		return responseBody
+	}
+	for i := range messages {
+		messages[i].MessageText = messages[i].Body
+	}
+	out, err := xml.Marshal(queueMessagesList{Messages: messages})
+	if err != nil {
+		return responseBody
+	}
+	return out
+}
+
+func escapeMessageText(message string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(message))
+	return buf.String()
+}
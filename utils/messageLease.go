@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMessageVisibility is how long ReceiveMessage hides a message at a
+// time. A consumer that dies stops extending it, so its message is
+// redelivered at most this long after the crash.
+const DefaultMessageVisibility = 2 * time.Minute
+
+// MaxDequeueCount is how often ReceiveMessage tries to open a message
+// before it moves the message, as received, to its dead-letter queue.
+// Messages encrypted with a key the keyring does not hold yet are
+// released and tried again however often they were received.
+var MaxDequeueCount = 5
+
+// DeadLetterQueue is the queue that failed messages of queueName move to.
+func DeadLetterQueue(queueName string) string {
+	return queueName + "-poison"
+}
+
+// MessageLease keeps a received message hidden from other consumers while
+// it is processed, extending its visibility in the background. The message
+// stays in the queue until Complete, so it is redelivered if the process
+// is killed before the job ends.
+type MessageLease struct {
+	queue      Queue
+	messageId  string
+	text       string
+	visibility time.Duration
+
+	lock       sync.Mutex
+	popReceipt string
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// ReceiveMessage receives a single message, unwrapping its envelope, and
+// keeps it hidden until the returned lease is completed or released. It
+// returns nil, nil, nil when the queue is empty. connString may name any
+// backend accepted by OpenQueue.
+func ReceiveMessage(connString string, queueName string, params ...time.Duration) (*QueueMessage, *MessageLease, error) {
+	visibility := DefaultMessageVisibility
+	if len(params) > 0 && params[0] > 0 {
+		visibility = params[0]
+	}
+
+	q := OpenQueue(connString, queueName)
+	messages, err := q.Receive(1, visibility)
+	if err != nil || len(messages) == 0 {
+		return nil, nil, err
+	}
+
+	msg := &messages[0]
+	lease := &MessageLease{
+		queue:      q,
+		messageId:  msg.MessageId,
+		text:       msg.MessageText,
+		visibility: visibility,
+		popReceipt: msg.PopReceipt,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go lease.renew(lease.stop)
+
+	msg.Body, msg.Envelope, err = OpenMessage(connString, msg.MessageText)
+	if err != nil {
+		// a claim-check blob or key may be back by the next delivery, so
+		// the message is kept until it has failed MaxDequeueCount times
+		if !IsUnknownKey(err) && msg.DequeueCount >= MaxDequeueCount {
+			if dlErr := lease.DeadLetter(connString, DeadLetterQueue(queueName)); dlErr == nil {
+				return nil, nil, errors.New("message " + msg.MessageId + " moved to " + DeadLetterQueue(queueName) + ": " + err.Error())
+			}
+		}
+		lease.Release()
+		return nil, nil, err
+	}
+	return msg, lease, nil
+}
+
+// renew extends the visibility at a third of its length until stopped.
+func (f *MessageLease) renew(stop chan struct{}) {
+	defer close(f.done)
+	ticker := time.NewTicker(f.visibility / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// a failed update is retried on the next tick
+		f.lock.Lock()
+		if popReceipt, err := f.queue.Update(f.messageId, f.popReceipt, f.text, f.visibility); err == nil {
+			f.popReceipt = popReceipt
+		}
+		f.lock.Unlock()
+	}
+}
+
+func (f *MessageLease) stopRenewing() {
+	f.lock.Lock()
+	stop := f.stop
+	f.stop = nil
+	f.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-f.done
+	}
+}
+
+// Complete stops extending the visibility and deletes the message.
+func (f *MessageLease) Complete() error {
+	f.stopRenewing()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.queue.Delete(f.messageId, f.popReceipt)
+}
+
+// DeadLetter moves the message, as it was received, to queueName, from
+// where Replay can send it back. The message is deleted only once the copy
+// has been sent; otherwise it stays leased until Release.
+func (f *MessageLease) DeadLetter(connString string, queueName string) error {
+	f.stopRenewing()
+	if _, err := OpenQueue(connString, queueName).Send(f.text, 0); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.queue.Delete(f.messageId, f.popReceipt)
+}
+
+// Release stops extending the visibility, so the message is redelivered
+// once its current visibility timeout runs out.
+func (f *MessageLease) Release() {
+	f.stopRenewing()
+}
@@ -0,0 +1,95 @@
+package utils_test
+
+import (
+	"main/utils"
+	"main/utils/storagetest"
+	"testing"
+	"time"
+)
+
+func TestMessageLease(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+
+	if _, err := utils.SendMessage(connString, "leased", "job", utils.MessageOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	msg, lease, err := utils.ReceiveMessage(connString, "leased", 3*time.Second)
+	if err != nil || msg == nil {
+		t.Fatalf("receive: %v %v", err, msg)
+	}
+
+	// renewals keep the message hidden past its first visibility timeout
+	time.Sleep(4 * time.Second)
+	if other, _, err := utils.ReceiveMessage(connString, "leased"); err != nil || other != nil {
+		t.Fatalf("leased message was received again: %v %v", err, other)
+	}
+
+	// a released message is redelivered once its visibility runs out
+	lease.Release()
+	time.Sleep(3 * time.Second)
+	again, lease, err := utils.ReceiveMessage(connString, "leased")
+	if err != nil || again == nil {
+		t.Fatalf("released message was not redelivered: %v", err)
+	}
+	if again.MessageId != msg.MessageId || again.DequeueCount != 2 {
+		t.Fatalf("redelivered %s with dequeue count %d", again.MessageId, again.DequeueCount)
+	}
+	if err := lease.Complete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnreadableMessage(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+
+	defer func(count int) { utils.MaxDequeueCount = count }(utils.MaxDequeueCount)
+	utils.MaxDequeueCount = 2
+	defer func(keyring *utils.Keyring) { utils.MessageKeyring = keyring }(utils.MessageKeyring)
+	utils.MessageKeyring = nil
+
+	// the claim-check blob is missing, so the message cannot be opened
+	missing := `{"aqpEnvelope":"aqp/1","claimCheck":{"container":"claims","blobName":"gone","size":4}}`
+	if _, err := utils.OpenQueue(connString, "unreadable").Send(missing, 0); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _, err := utils.ReceiveMessage(connString, "unreadable", time.Second); err == nil {
+		t.Fatalf("opened %v", msg)
+	}
+	if count, _ := utils.OpenQueue(connString, "unreadable").Count(); count != 1 {
+		t.Fatalf("message was deleted after the first failure: %d left", count)
+	}
+
+	time.Sleep(2 * time.Second)
+	if msg, _, err := utils.ReceiveMessage(connString, "unreadable", time.Second); err == nil {
+		t.Fatalf("opened %v", msg)
+	}
+	if count, _ := utils.OpenQueue(connString, "unreadable").Count(); count != 0 {
+		t.Fatalf("%d messages left after dead-lettering", count)
+	}
+	poison, err := utils.OpenQueue(connString, utils.DeadLetterQueue("unreadable")).Peek(1)
+	if err != nil || len(poison) != 1 || poison[0].MessageText != missing {
+		t.Fatalf("dead-lettered %v %v", poison, err)
+	}
+
+	// a message for a key that is not rolled out yet is only released
+	encrypted := `{"aqpEnvelope":"aqp/1","keyId":"next","body":"AAAA"}`
+	if _, err := utils.OpenQueue(connString, "newkey").Send(encrypted, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err := utils.ReceiveMessage(connString, "newkey", time.Second); !utils.IsUnknownKey(err) {
+			t.Fatalf("receive %d: %v", i, err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+	if count, _ := utils.OpenQueue(connString, "newkey").Count(); count != 1 {
+		t.Fatalf("message for an unknown key was moved: %d left", count)
+	}
+	if count, _ := utils.OpenQueue(connString, utils.DeadLetterQueue("newkey")).Count(); count != 0 {
+		t.Fatalf("message for an unknown key was dead-lettered")
+	}
+}
@@ -0,0 +1,91 @@
+package utils_test
+
+import (
+	"main/utils"
+	"main/utils/storagetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	storage := storagetest.NewServer()
+	defer storage.Close()
+	connString := storage.ConnectionString()
+	defer func(keyring *utils.Keyring) { utils.MessageKeyring = keyring }(utils.MessageKeyring)
+	keyring, err := utils.ParseKeyring("k1:" + testKey(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.MessageKeyring = keyring
+
+	large := strings.Repeat("x", utils.MaxQueueMessageSize+1)
+	for _, c := range []struct {
+		name string
+		body string
+		opts utils.MessageOptions
+	}{
+		{"plain", `{"Parameters":{"a":"<b>&'\""}}`, utils.MessageOptions{}},
+		{"gzip", strings.Repeat("compress me ", 100), utils.MessageOptions{Codec: utils.CodecGzip}},
+		{"claim-check", large, utils.MessageOptions{ClaimCheckContainer: "claims"}},
+		{"retried", "again", utils.MessageOptions{Attempt: 2, JobID: "job-1"}},
+		{"encrypted", "top secret", utils.MessageOptions{Encrypt: true}},
+		{"encrypted-gzip-claim-check", large, utils.MessageOptions{Codec: utils.CodecGzip, Encrypt: true, ClaimCheckContainer: "claims", ClaimCheckThreshold: 100}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			queueName := "round-trip-" + c.name
+			if _, err := utils.SendMessage(connString, queueName, c.body, c.opts); err != nil {
+				t.Fatal(err)
+			}
+			peeked, err := utils.PeekQueueMessages(connString, queueName)
+			if err != nil || len(peeked) != 1 {
+				t.Fatalf("peek: %v %d", err, len(peeked))
+			}
+			if len(peeked[0].MessageText) > utils.MaxQueueMessageSize {
+				t.Fatalf("message text of %d bytes was not claim-checked", len(peeked[0].MessageText))
+			}
+			if c.opts.Encrypt && strings.Contains(peeked[0].MessageText, c.body[:5]) {
+				t.Fatalf("encrypted message text shows the body: %q", peeked[0].MessageText)
+			}
+
+			q := utils.OpenQueue(connString, queueName)
+			messages, err := q.Receive(1, time.Minute)
+			if err != nil || len(messages) != 1 {
+				t.Fatalf("receive: %v %d", err, len(messages))
+			}
+			msg := &messages[0]
+			msg.Body, msg.Envelope, err = utils.OpenMessage(connString, msg.MessageText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Body != c.body {
+				t.Fatalf("body: got %q", msg.Body)
+			}
+			if msg.Attempt() != c.opts.Attempt {
+				t.Fatalf("attempt: got %d", msg.Attempt())
+			}
+			if c.opts.JobID != "" && msg.JobID() != c.opts.JobID {
+				t.Fatalf("job ID: got %q", msg.JobID())
+			}
+			if err := q.Delete(msg.MessageId, msg.PopReceipt); err != nil {
+				t.Fatal(err)
+			}
+			if count, err := utils.GetQueueMessageCount(connString, queueName); err != nil || count != 0 {
+				t.Fatalf("count after delete: %d %v", count, err)
+			}
+		})
+	}
+}
+
+func TestClaimCheckOnLocalBackend(t *testing.T) {
+	opts := utils.MessageOptions{ClaimCheckContainer: "claims", ClaimCheckThreshold: 10}
+	for _, connString := range []string{"memory:", "file:" + t.TempDir()} {
+		if _, err := utils.SendMessage(connString, "local", strings.Repeat("x", 100), opts); err != utils.ErrClaimCheckBackend {
+			t.Errorf("%s: send with claim-check: %v", connString, err)
+		}
+		claimChecked := `{"aqpEnvelope":"aqp/1","claimCheck":{"container":"claims","blobName":"local/x","size":100}}`
+		if _, _, err := utils.OpenMessage(connString, claimChecked); err != utils.ErrClaimCheckBackend {
+			t.Errorf("%s: open claim-checked message: %v", connString, err)
+		}
+	}
+}
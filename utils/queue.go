@@ -1,27 +1,43 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/stretchr/objx"
 )
 
-func PostQueue(connString string, queueName string, message string) (res *HttpPost) {
+func PostQueue(connString string, queueName string, message string, params ...MessageOptions) (res *HttpPost) {
+
+	var opts MessageOptions
+	if len(params) > 0 {
+		opts = params[0]
+	}
 
 	credential, err := NewSharedKeyCredential(connString)
 	if err != nil {
 		fmt.Println("NewSharedKeyCredential err")
 	}
 
-	URI := "https://" + credential.AccountName() + ".queue.core.windows.net/" + queueName + "/messages"
-	template := "<QueueMessage><MessageText>" + message + "</MessageText></QueueMessage>"
+	URI := credential.QueueURL(queueName + "/messages")
+	if opts.VisibilityTimeout > 0 {
+		URI += "?visibilitytimeout=" + strconv.Itoa(int(opts.VisibilityTimeout/time.Second))
+	}
 
 	post := &HttpPost{
-		URI:         URI,
-		RequestBody: []byte(template),
+		URI: URI,
+	}
+
+	message, post.Error = SealMessage(connString, queueName, message, opts)
+	if post.Error != nil {
+		return post
 	}
+	template := "<QueueMessage><MessageText>" + escapeMessageText(message) + "</MessageText></QueueMessage>"
+	post.RequestBody = []byte(template)
+
 	err = credential.HttpPostRequest(post)
 	post.ResponseBody = []byte(XML2JSON(string(post.ResponseBody)))
 	// if err != nil {
@@ -41,7 +57,7 @@ func GetQueue(connString string, queueName string) (res *HttpGet) {
 		fmt.Println("NewSharedKeyCredential err")
 	}
 
-	URI := "https://" + credential.AccountName() + ".queue.core.windows.net/" + queueName + "/messages?numofmessages=1"
+	URI := credential.QueueURL(queueName+"/messages", "numofmessages=1")
 
 	get := &HttpGet{
 		URI: URI,
@@ -70,13 +86,13 @@ func PeekQueue(connString string, queueName string, params ...int) (res *HttpGet
 		fmt.Println("NewSharedKeyCredential err")
 	}
 
-	URI := "https://" + credential.AccountName() + ".queue.core.windows.net/" + queueName + "/messages?peekonly=true&numofmessages=" + strconv.Itoa(count)
+	URI := credential.QueueURL(queueName+"/messages", "peekonly=true&numofmessages="+strconv.Itoa(count))
 
 	get := &HttpGet{
 		URI: URI,
 	}
 	err = credential.HttpGetRequest(get)
-	get.ResponseBody = []byte(XML2JSON(string(get.ResponseBody)))
+	get.ResponseBody = []byte(XML2JSON(string(openQueueMessagesXML(connString, get.ResponseBody))))
 	// if err != nil {
 	// 	fmt.Println("HttpGetRequest err")
 
@@ -87,6 +103,136 @@ func PeekQueue(connString string, queueName string, params ...int) (res *HttpGet
 	return get
 }
 
+// PeekQueueMessages returns up to count messages without changing their
+// visibility, with each envelope unwrapped.
+func PeekQueueMessages(connString string, queueName string, params ...int) ([]QueueMessage, error) {
+
+	count := 32
+	if len(params) > 0 {
+		count = params[0]
+	}
+
+	messages, err := OpenQueue(connString, queueName).Peek(count)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		msg := &messages[i]
+		msg.Body, msg.Envelope, err = OpenMessage(connString, msg.MessageText)
+		if err != nil {
+			return messages, err
+		}
+	}
+	return messages, nil
+}
+
+func peekQueueMessages(connString string, queueName string, count int) ([]QueueMessage, error) {
+
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	URI := credential.QueueURL(queueName+"/messages", "peekonly=true&numofmessages="+strconv.Itoa(count))
+
+	get := &HttpGet{
+		URI: URI,
+	}
+	if err = credential.HttpGetRequest(get); err != nil {
+		return nil, err
+	}
+	if get.StatusCode != 200 {
+		return nil, errors.New("peek failed: " + strconv.Itoa(get.StatusCode) + " " + XML2JSON(string(get.ResponseBody)))
+	}
+	return readQueueMessages(get.ResponseBody)
+}
+
+// GetQueueMessages receives up to count messages without deleting them.
+// They stay invisible to other consumers for visibilityTimeout seconds.
+// Envelopes are detected but not unwrapped; use OpenMessage to read a body.
+func GetQueueMessages(connString string, queueName string, count int, visibilityTimeout int) ([]QueueMessage, error) {
+
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	URI := credential.QueueURL(queueName+"/messages", "numofmessages="+strconv.Itoa(count)+"&visibilitytimeout="+strconv.Itoa(visibilityTimeout))
+
+	get := &HttpGet{
+		URI: URI,
+	}
+	if err = credential.HttpGetRequest(get); err != nil {
+		return nil, err
+	}
+	if get.StatusCode != 200 {
+		return nil, errors.New("get messages failed: " + strconv.Itoa(get.StatusCode) + " " + XML2JSON(string(get.ResponseBody)))
+	}
+	return readQueueMessages(get.ResponseBody)
+}
+
+// UpdateQueueMessage replaces the text of a received message and sets how
+// long it stays invisible. The new pop receipt is in the x-ms-popreceipt
+// response header.
+func UpdateQueueMessage(connString string, queueName string, messageid string, popreceipt string, message string, visibilityTimeout int) (res *HttpPost) {
+
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		fmt.Println("NewSharedKeyCredential err")
+	}
+
+	URI := credential.QueueURL(queueName+"/messages/"+messageid, "popreceipt="+url.QueryEscape(popreceipt)+"&visibilitytimeout="+strconv.Itoa(visibilityTimeout))
+	template := "<QueueMessage><MessageText>" + escapeMessageText(message) + "</MessageText></QueueMessage>"
+
+	post := &HttpPost{
+		URI:         URI,
+		RequestBody: []byte(template),
+	}
+	err = credential.HttpPutRequest(post)
+	post.ResponseBody = []byte(XML2JSON(string(post.ResponseBody)))
+	return post
+}
+
+// ClearQueue deletes every message in the queue.
+func ClearQueue(connString string, queueName string) (res *HttpGet) {
+
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		fmt.Println("NewSharedKeyCredential err")
+	}
+
+	URI := credential.QueueURL(queueName + "/messages")
+
+	delete := &HttpGet{
+		URI: URI,
+	}
+	err = credential.HttpDeleteRequest(delete)
+	delete.ResponseBody = []byte(XML2JSON(string(delete.ResponseBody)))
+	return delete
+}
+
+// GetQueueMessageCount returns the approximate number of messages in the queue.
+func GetQueueMessageCount(connString string, queueName string) (int, error) {
+
+	credential, err := NewSharedKeyCredential(connString)
+	if err != nil {
+		return -1, err
+	}
+
+	URI := credential.QueueURL(queueName, "comp=metadata")
+
+	get := &HttpGet{
+		URI: URI,
+	}
+	if err = credential.HttpGetRequest(get); err != nil {
+		return -1, err
+	}
+	if get.StatusCode != 200 {
+		return -1, errors.New("get queue metadata failed: " + strconv.Itoa(get.StatusCode) + " " + XML2JSON(string(get.ResponseBody)))
+	}
+	return strconv.Atoi(get.Response.Header.Get("x-ms-approximate-messages-count"))
+}
+
 func DeleteQueue(connString string, queueName string, messageid string, popreceipt string) (res *HttpGet) {
 
 	credential, err := NewSharedKeyCredential(connString)
@@ -94,7 +240,7 @@ func DeleteQueue(connString string, queueName string, messageid string, poprecei
 		fmt.Println("NewSharedKeyCredential err")
 	}
 
-	URI := "https://" + credential.AccountName() + ".queue.core.windows.net/" + queueName + "/messages/" + messageid + "?popreceipt=" + url.QueryEscape(popreceipt)
+	URI := credential.QueueURL(queueName+"/messages/"+messageid, "popreceipt="+url.QueryEscape(popreceipt))
 
 	delete := &HttpGet{
 		URI: URI,
@@ -129,7 +275,27 @@ func DeQueue(connString string, queueName string) (res *HttpGet) {
 		return delete
 	}
 	messageText := jobject.Get("QueueMessagesList.QueueMessage.MessageText").Str()
-	get.ResponseBody = []byte(messageText)
+	body, _, err := OpenMessage(connString, messageText)
+	get.ResponseBody = []byte(body)
+	get.Error = err
 
 	return get
 }
+
+// DeQueueMessage receives and deletes a single message, unwrapping its
+// envelope. It returns nil, nil when the queue is empty. connString may
+// name any backend accepted by OpenQueue.
+func DeQueueMessage(connString string, queueName string) (*QueueMessage, error) {
+	q := OpenQueue(connString, queueName)
+	messages, err := q.Receive(1, 30*time.Second)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+
+	msg := &messages[0]
+	if err := q.Delete(msg.MessageId, msg.PopReceipt); err != nil {
+		return nil, err
+	}
+	msg.Body, msg.Envelope, err = OpenMessage(connString, msg.MessageText)
+	return msg, err
+}
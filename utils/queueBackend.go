@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Queue is a message queue backend. It moves raw message text; envelopes
+// are detected on the messages it returns but sealing and opening them is
+// left to SealMessage and OpenMessage.
+type Queue interface {
+	// Send adds a message that becomes visible after visibilityTimeout and
+	// returns its message ID.
+	Send(messageText string, visibilityTimeout time.Duration) (string, error)
+	// Receive returns up to count visible messages and hides them for visibilityTimeout.
+	Receive(count int, visibilityTimeout time.Duration) ([]QueueMessage, error)
+	Delete(messageId string, popReceipt string) error
+	// Update replaces the text of a received message, hides it for
+	// visibilityTimeout and returns its new pop receipt.
+	Update(messageId string, popReceipt string, messageText string, visibilityTimeout time.Duration) (string, error)
+	// Peek returns up to count visible messages without hiding them.
+	Peek(count int) ([]QueueMessage, error)
+	// Count returns the approximate number of messages, visible or not.
+	Count() (int, error)
+	// Clear deletes every message.
+	Clear() error
+}
+
+var (
+	memoryQueues     = make(map[string]*MemoryQueue)
+	memoryQueuesLock sync.Mutex
+)
+
+// OpenQueue returns the backend named by connString:
+//
+//	memory:      a process-wide in-memory queue, shared by every OpenQueue call
+//	file:<dir>   a durable queue stored as files under dir
+//	otherwise    an Azure Storage account connection string
+func OpenQueue(connString string, queueName string) Queue {
+	switch {
+	case strings.HasPrefix(connString, "memory:"):
+		memoryQueuesLock.Lock()
+		defer memoryQueuesLock.Unlock()
+		key := connString + "/" + queueName
+		if memoryQueues[key] == nil {
+			memoryQueues[key] = NewMemoryQueue()
+		}
+		return memoryQueues[key]
+	case strings.HasPrefix(connString, "file:"):
+		return NewFileQueue(strings.TrimPrefix(connString, "file:"), queueName)
+	}
+	return NewAzureQueue(connString, queueName)
+}
+
+// IsLocalBackend reports whether connString names the memory: or file:
+// backend rather than a storage account.
+func IsLocalBackend(connString string) bool {
+	return strings.HasPrefix(connString, "memory:") || strings.HasPrefix(connString, "file:")
+}
+
+// SendMessage seals message with opts and sends it to the queue backend
+// named by connString. It returns the new message ID.
+func SendMessage(connString string, queueName string, message string, opts MessageOptions) (string, error) {
+	messageText, err := SealMessage(connString, queueName, message, opts)
+	if err != nil {
+		return "", err
+	}
+	return OpenQueue(connString, queueName).Send(messageText, opts.VisibilityTimeout)
+}
+
+// storedMessage is a message held by the memory and file backends.
+type storedMessage struct {
+	MessageId      string
+	MessageText    string
+	InsertionTime  time.Time
+	ExpirationTime time.Time
+	NextVisible    time.Time
+	PopReceipt     string
+	DequeueCount   int
+}
+
+// messageTimeToLive matches the queue service's default of seven days.
+const messageTimeToLive = 7 * 24 * time.Hour
+
+func newStoredMessage(messageText string, visibilityTimeout time.Duration) *storedMessage {
+	now := time.Now().UTC()
+	return &storedMessage{
+		// IDs start with the insertion time so that they sort in queue order.
+		MessageId:      now.Format("20060102T150405.000000000") + "-" + randomHex(8),
+		MessageText:    messageText,
+		InsertionTime:  now,
+		ExpirationTime: now.Add(messageTimeToLive),
+		NextVisible:    now.Add(visibilityTimeout),
+		PopReceipt:     randomHex(16),
+	}
+}
+
+func (f *storedMessage) visible(now time.Time) bool {
+	return !now.Before(f.NextVisible) && now.Before(f.ExpirationTime)
+}
+
+func (f *storedMessage) expired(now time.Time) bool {
+	return !now.Before(f.ExpirationTime)
+}
+
+// receive hides the message and hands out a new pop receipt.
+func (f *storedMessage) receive(now time.Time, visibilityTimeout time.Duration) {
+	f.DequeueCount++
+	f.PopReceipt = randomHex(16)
+	f.NextVisible = now.Add(visibilityTimeout)
+}
+
+func (f *storedMessage) queueMessage(withReceipt bool) QueueMessage {
+	msg := QueueMessage{
+		MessageId:      f.MessageId,
+		InsertionTime:  f.InsertionTime.Format(http.TimeFormat),
+		ExpirationTime: f.ExpirationTime.Format(http.TimeFormat),
+		DequeueCount:   f.DequeueCount,
+		MessageText:    f.MessageText,
+		Envelope:       ParseEnvelope(f.MessageText),
+	}
+	if withReceipt {
+		msg.PopReceipt = f.PopReceipt
+		msg.TimeNextVisible = f.NextVisible.Format(http.TimeFormat)
+	}
+	return msg
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
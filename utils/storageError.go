@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// StorageError is an error response from the storage service.
+type StorageError struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (f *StorageError) Error() string {
+	if f.Code == "" {
+		return "storage request failed: " + strconv.Itoa(f.StatusCode)
+	}
+	return "storage request failed: " + strconv.Itoa(f.StatusCode) + " " + f.Code + ": " + f.Message
+}
+
+// IsNotFound reports whether err is a 404 from the storage service.
+func IsNotFound(err error) bool {
+	return ErrorStatus(err) == http.StatusNotFound
+}
+
+// newStorageError reads the error in resp and closes its body. Responses
+// without a body, such as HEAD, take the code from x-ms-error-code.
+func newStorageError(resp *http.Response) error {
+	defer resp.Body.Close()
+	storageErr := &StorageError{StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = xml.Unmarshal(body, storageErr)
+	if storageErr.Code == "" {
+		storageErr.Code = resp.Header.Get("x-ms-error-code")
+	}
+	return storageErr
+}
+
+// ErrorStatus returns the HTTP status of a StorageError, or 0 for any other error.
+func ErrorStatus(err error) int {
+	if storageErr, ok := err.(*StorageError); ok {
+		return storageErr.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,545 @@
+package storagetest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"main/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fakeBlob struct {
+	data               []byte
+	blobType           string
+	contentType        string
+	contentEncoding    string
+	contentLanguage    string
+	contentDisposition string
+	cacheControl       string
+	contentMD5         string
+	accessTier         string
+	etag               string
+	created            time.Time
+	lastModified       time.Time
+	metadata           map[string]string
+	committedBlocks    int
+	blocks             []utils.Block
+	lease              fakeLease
+	tags               map[string]string
+}
+
+func (f *fakeBlob) touch() {
+	f.etag = "\"0x" + strings.ToUpper(randomHex(8)) + "\""
+	f.lastModified = time.Now().UTC()
+}
+
+func (f *fakeBlob) writeHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set(headerContentLength, strconv.Itoa(len(f.data)))
+	h.Set(headerContentType, f.contentType)
+	if f.contentEncoding != "" {
+		h.Set(headerContentEncoding, f.contentEncoding)
+	}
+	if f.contentLanguage != "" {
+		h.Set(headerContentLanguage, f.contentLanguage)
+	}
+	if f.contentDisposition != "" {
+		h.Set(headerContentDisposition, f.contentDisposition)
+	}
+	if f.cacheControl != "" {
+		h.Set(headerCacheControl, f.cacheControl)
+	}
+	if f.contentMD5 != "" {
+		h.Set(headerContentMD5, f.contentMD5)
+	}
+	h.Set("ETag", f.etag)
+	h.Set("Last-Modified", f.lastModified.Format(http.TimeFormat))
+	h.Set("x-ms-creation-time", f.created.Format(http.TimeFormat))
+	h.Set("x-ms-blob-type", f.blobType)
+	if f.blobType == "BlockBlob" {
+		if f.accessTier == "" {
+			h.Set("x-ms-access-tier", "Hot")
+			h.Set("x-ms-access-tier-inferred", "true")
+		} else {
+			h.Set("x-ms-access-tier", f.accessTier)
+		}
+	}
+	f.lease.writeHeaders(w)
+	for k, v := range f.metadata {
+		h.Set("x-ms-meta-"+k, v)
+	}
+}
+
+func (f *fakeBlob) setHTTPHeaders(r *http.Request) {
+	f.contentType = r.Header.Get("x-ms-blob-content-type")
+	if f.contentType == "" {
+		f.contentType = "application/octet-stream"
+	}
+	f.contentEncoding = r.Header.Get("x-ms-blob-content-encoding")
+	f.contentLanguage = r.Header.Get("x-ms-blob-content-language")
+	f.contentDisposition = r.Header.Get("x-ms-blob-content-disposition")
+	f.cacheControl = r.Header.Get("x-ms-blob-cache-control")
+	if md5 := r.Header.Get("x-ms-blob-content-md5"); md5 != "" {
+		f.contentMD5 = md5
+	}
+}
+
+// serveBlob handles /<account>/<container>[/<blob>].
+func (f *Server) serveBlob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"+f.AccountName()+"/"), "/", 2)
+	query := r.URL.Query()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	container := parts[0]
+	if container == "" {
+		if r.Method == http.MethodGet && query.Get("comp") == "list" {
+			f.listContainers(w, query)
+			return
+		}
+		if r.Method == http.MethodGet && query.Get("comp") == "blobs" {
+			f.findBlobs(w, query)
+			return
+		}
+		writeStorageError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+		return
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		if query.Get("restype") == "container" {
+			f.serveContainer(w, r, container)
+			return
+		}
+		writeStorageError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+		return
+	}
+	if f.containers[container] == nil {
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-error-code", "ContainerNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+
+	name := parts[1]
+	blob := f.blobs[container][name]
+
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		data, _ := ioutil.ReadAll(r.Body)
+		sum := md5.Sum(data)
+		if md5 := r.Header.Get(headerContentMD5); md5 != "" && md5 != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeStorageError(w, http.StatusBadRequest, "Md5Mismatch", "The MD5 value specified in the request did not match with the MD5 value calculated by the server.")
+			return
+		}
+		key := container + "/" + name
+		if f.uncommitted[key] == nil {
+			f.uncommitted[key] = make(map[string][]byte)
+		}
+		f.uncommitted[key][query.Get("blockid")] = data
+		w.Header().Set(headerContentMD5, base64.StdEncoding.EncodeToString(sum[:]))
+		w.WriteHeader(http.StatusCreated)
+		return
+
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		if !leaseAllowsWrite(w, r, blob) {
+			return
+		}
+		f.commitBlockList(w, r, container, name)
+		return
+
+	case r.Method == http.MethodGet && query.Get("comp") == "blocklist":
+		var list utils.BlockList
+		listType := query.Get("blocklisttype")
+		if blob != nil && listType != "uncommitted" {
+			list.CommittedBlocks = blob.blocks
+		}
+		if listType == "uncommitted" || listType == "all" {
+			for id, data := range f.uncommitted[container+"/"+name] {
+				list.UncommittedBlocks = append(list.UncommittedBlocks, utils.Block{Name: id, Size: int64(len(data))})
+			}
+			sort.Slice(list.UncommittedBlocks, func(i, j int) bool { return list.UncommittedBlocks[i].Name < list.UncommittedBlocks[j].Name })
+		}
+		if blob == nil && len(list.UncommittedBlocks) == 0 {
+			writeStorageError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+		out, _ := xml.Marshal(list)
+		w.Header().Set(headerContentType, "application/xml")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(xml.Header))
+		_, _ = w.Write(out)
+		return
+	}
+	if blob == nil && r.Method != http.MethodPut {
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeStorageError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+
+	if r.Method == http.MethodPut && query.Get("comp") == "lease" {
+		serveLease(w, r, blob)
+		return
+	}
+	if query.Get("comp") == "tags" {
+		serveTags(w, r, blob)
+		return
+	}
+	if (r.Method == http.MethodPut && query.Get("comp") != "") || r.Method == http.MethodDelete {
+		if !leaseAllowsWrite(w, r, blob) {
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "":
+		if blob != nil && r.Header.Get(headerIfNoneMatch) == "*" {
+			writeStorageError(w, http.StatusConflict, "BlobAlreadyExists", "The specified blob already exists.")
+			return
+		}
+		if !conditionsMet(w, r, blob) || !leaseAllowsWrite(w, r, blob) {
+			return
+		}
+		old := blob
+		blobType := r.Header.Get("x-ms-blob-type")
+		if blobType == "" {
+			writeStorageError(w, http.StatusBadRequest, "MissingRequiredHeader", "An HTTP header that's mandatory for this request is not specified.")
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		sum := md5.Sum(data)
+		if md5 := r.Header.Get(headerContentMD5); md5 != "" && md5 != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeStorageError(w, http.StatusBadRequest, "Md5Mismatch", "The MD5 value specified in the request did not match with the MD5 value calculated by the server.")
+			return
+		}
+		blob = &fakeBlob{
+			data:       data,
+			blobType:   blobType,
+			contentMD5: base64.StdEncoding.EncodeToString(sum[:]),
+			accessTier: r.Header.Get("x-ms-access-tier"),
+			tags:       tagsFromHeader(r.Header),
+			created:    time.Now().UTC(),
+			metadata:   metadataFromHeader(r.Header),
+		}
+		if old != nil {
+			blob.lease = old.lease
+		}
+		blob.setHTTPHeaders(r)
+		blob.touch()
+		f.blobs[container][name] = blob
+		w.Header().Set("ETag", blob.etag)
+		w.Header().Set("Last-Modified", blob.lastModified.Format(http.TimeFormat))
+		w.Header().Set(headerContentMD5, base64.StdEncoding.EncodeToString(sum[:]))
+		w.Header().Set("x-ms-request-server-encrypted", "true")
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodPut && query.Get("comp") == "appendblock":
+		if blob.blobType != "AppendBlob" {
+			writeStorageError(w, http.StatusConflict, "InvalidBlobType", "The blob type is invalid for this operation.")
+			return
+		}
+		if pos := r.Header.Get("x-ms-blob-condition-appendpos"); pos != "" && pos != strconv.Itoa(len(blob.data)) {
+			writeStorageError(w, http.StatusPreconditionFailed, "AppendPositionConditionNotMet", "The append position condition specified was not met.")
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		if len(data) > utils.MaxAppendBlockSize {
+			writeStorageError(w, http.StatusRequestEntityTooLarge, "RequestBodyTooLarge", "The request body is too large.")
+			return
+		}
+		offset := len(blob.data)
+		blob.data = append(blob.data, data...)
+		blob.committedBlocks++
+		blob.contentMD5 = ""
+		blob.touch()
+		w.Header().Set("ETag", blob.etag)
+		w.Header().Set("x-ms-blob-append-offset", strconv.Itoa(offset))
+		w.Header().Set("x-ms-blob-committed-block-count", strconv.Itoa(blob.committedBlocks))
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodPut && query.Get("comp") == "properties":
+		blob.setHTTPHeaders(r)
+		blob.touch()
+		w.Header().Set("ETag", blob.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPut && query.Get("comp") == "metadata":
+		blob.metadata = metadataFromHeader(r.Header)
+		blob.touch()
+		w.Header().Set("ETag", blob.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodGet && query.Get("comp") == "metadata":
+		for k, v := range blob.metadata {
+			w.Header().Set("x-ms-meta-"+k, v)
+		}
+		w.Header().Set("ETag", blob.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodHead:
+		blob.writeHeaders(w)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodGet:
+		if match := r.Header.Get(headerIfMatch); match != "" && match != "*" && match != blob.etag {
+			writeStorageError(w, http.StatusPreconditionFailed, "ConditionNotMet", "The condition specified using HTTP conditional header(s) is not met.")
+			return
+		}
+		serveBlobRange(w, r, blob)
+
+	case r.Method == http.MethodDelete:
+		delete(f.blobs[container], name)
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeStorageError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+	}
+}
+
+// serveBlobRange writes the blob, or the part of it asked for by a Range
+// header, with the MD5 of the range when x-ms-range-get-content-md5 is set.
+func serveBlobRange(w http.ResponseWriter, r *http.Request, blob *fakeBlob) {
+	byteRange := r.Header.Get(headerRange)
+	if byteRange == "" {
+		byteRange = r.Header.Get("x-ms-range")
+	}
+	if byteRange == "" {
+		blob.writeHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(blob.data)
+		return
+	}
+
+	size := int64(len(blob.data))
+	first, last := int64(-1), size-1
+	if bounds := strings.SplitN(strings.TrimPrefix(byteRange, "bytes="), "-", 2); len(bounds) == 2 {
+		first, _ = strconv.ParseInt(bounds[0], 10, 64)
+		if bounds[1] != "" {
+			if n, err := strconv.ParseInt(bounds[1], 10, 64); err == nil && n < last {
+				last = n
+			}
+		}
+	}
+	if first < 0 || first >= size || last < first {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		writeStorageError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The range specified is invalid for the current size of the resource.")
+		return
+	}
+	data := blob.data[first : last+1]
+	if r.Header.Get("x-ms-range-get-content-md5") == "true" && len(data) > utils.MaxRangeMD5Size {
+		writeStorageError(w, http.StatusBadRequest, "OutOfRangeInput", "One of the request inputs is out of range.")
+		return
+	}
+
+	blob.writeHeaders(w)
+	h := w.Header()
+	h.Del(headerContentMD5)
+	if blob.contentMD5 != "" {
+		h.Set("x-ms-blob-content-md5", blob.contentMD5)
+	}
+	if r.Header.Get("x-ms-range-get-content-md5") == "true" {
+		sum := md5.Sum(data)
+		h.Set(headerContentMD5, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	h.Set(headerContentLength, strconv.Itoa(len(data)))
+	h.Set("Content-Range", "bytes "+strconv.FormatInt(first, 10)+"-"+strconv.FormatInt(last, 10)+"/"+strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = w.Write(data)
+}
+
+// conditionsMet checks If-Match and If-None-Match against the blob's ETag.
+func conditionsMet(w http.ResponseWriter, r *http.Request, blob *fakeBlob) bool {
+	etag := ""
+	if blob != nil {
+		etag = blob.etag
+	}
+	if match := r.Header.Get(headerIfMatch); match != "" && (blob == nil || (match != "*" && match != etag)) {
+		writeStorageError(w, http.StatusPreconditionFailed, "ConditionNotMet", "The condition specified using HTTP conditional header(s) is not met.")
+		return false
+	}
+	if noneMatch := r.Header.Get(headerIfNoneMatch); noneMatch != "" && blob != nil && (noneMatch == "*" || noneMatch == etag) {
+		writeStorageError(w, http.StatusPreconditionFailed, "ConditionNotMet", "The condition specified using HTTP conditional header(s) is not met.")
+		return false
+	}
+	return true
+}
+
+func (f *Server) commitBlockList(w http.ResponseWriter, r *http.Request, container string, name string) {
+	var request struct {
+		Items []xmlElement `xml:",any"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := xml.Unmarshal(body, &request); err != nil {
+		writeStorageError(w, http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+		return
+	}
+
+	key := container + "/" + name
+	old := f.blobs[container][name]
+	committed := make(map[string][]byte)
+	if old != nil && old.blobType == "BlockBlob" {
+		offset := 0
+		for _, block := range old.blocks {
+			committed[block.Name] = old.data[offset : offset+int(block.Size)]
+			offset += int(block.Size)
+		}
+	}
+
+	blob := &fakeBlob{blobType: "BlockBlob", created: time.Now().UTC(), metadata: metadataFromHeader(r.Header), tags: tagsFromHeader(r.Header)}
+	for _, item := range request.Items {
+		data, ok := f.uncommitted[key][item.Value]
+		if item.XMLName.Local == "Committed" || (!ok && item.XMLName.Local == "Latest") {
+			data, ok = committed[item.Value]
+		}
+		if !ok {
+			writeStorageError(w, http.StatusBadRequest, "InvalidBlockList", "The specified block list is invalid.")
+			return
+		}
+		blob.data = append(blob.data, data...)
+		blob.blocks = append(blob.blocks, utils.Block{Name: item.Value, Size: int64(len(data))})
+	}
+	if old != nil {
+		blob.created = old.created
+		blob.lease = old.lease
+	}
+	blob.committedBlocks = len(blob.blocks)
+	blob.setHTTPHeaders(r)
+	blob.touch()
+	f.blobs[container][name] = blob
+	delete(f.uncommitted, key)
+
+	w.Header().Set("ETag", blob.etag)
+	w.Header().Set("Last-Modified", blob.lastModified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *Server) listBlobs(w http.ResponseWriter, container string, query map[string][]string) {
+	get := func(key string) string {
+		if v := query[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, delimiter, marker := get("prefix"), get("delimiter"), get("marker")
+	maxResults := queryInt(query, "maxresults", 5000)
+	includeMetadata := strings.Contains(get("include"), "metadata")
+
+	names := make([]string, 0, len(f.blobs[container]))
+	for name := range f.blobs[container] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type blobXML struct {
+		Name       string
+		Properties blobPropertiesXML
+		Metadata   *struct {
+			Items []xmlElement `xml:",any"`
+		} `xml:",omitempty"`
+	}
+	type prefixXML struct {
+		Name string
+	}
+	var list struct {
+		XMLName       xml.Name `xml:"EnumerationResults"`
+		ContainerName string   `xml:"ContainerName,attr"`
+		Prefix        string   `xml:",omitempty"`
+		Marker        string   `xml:",omitempty"`
+		MaxResults    int      `xml:",omitempty"`
+		Delimiter     string   `xml:",omitempty"`
+		Blobs         struct {
+			Blob       []blobXML   `xml:"Blob"`
+			BlobPrefix []prefixXML `xml:"BlobPrefix"`
+		}
+		NextMarker string
+	}
+	list.ContainerName, list.Prefix, list.Marker, list.Delimiter = container, prefix, marker, delimiter
+	if _, ok := query["maxresults"]; ok {
+		list.MaxResults = maxResults
+	}
+
+	seenPrefixes := make(map[string]bool)
+	count := 0
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || name < marker {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				p := name[:len(prefix)+i+len(delimiter)]
+				if seenPrefixes[p] {
+					continue
+				}
+				if count == maxResults {
+					list.NextMarker = name
+					break
+				}
+				seenPrefixes[p] = true
+				list.Blobs.BlobPrefix = append(list.Blobs.BlobPrefix, prefixXML{Name: p})
+				count++
+				continue
+			}
+		}
+		if count == maxResults {
+			list.NextMarker = name
+			break
+		}
+		blob := f.blobs[container][name]
+		item := blobXML{Name: name, Properties: blobPropertiesXML{
+			CreationTime:       blob.created.Format(http.TimeFormat),
+			LastModified:       blob.lastModified.Format(http.TimeFormat),
+			ETag:               blob.etag,
+			ContentLength:      int64(len(blob.data)),
+			ContentType:        blob.contentType,
+			ContentEncoding:    blob.contentEncoding,
+			ContentLanguage:    blob.contentLanguage,
+			ContentDisposition: blob.contentDisposition,
+			CacheControl:       blob.cacheControl,
+			ContentMD5:         blob.contentMD5,
+			BlobType:           blob.blobType,
+			AccessTier:         blob.accessTier,
+			LeaseState:         blob.lease.state(time.Now()),
+		}}
+		if blob.blobType == "BlockBlob" && item.Properties.AccessTier == "" {
+			item.Properties.AccessTier = "Hot"
+		}
+		if blob.lease.active(time.Now()) {
+			item.Properties.LeaseStatus = "locked"
+		} else {
+			item.Properties.LeaseStatus = "unlocked"
+		}
+		if includeMetadata {
+			item.Metadata = &struct {
+				Items []xmlElement `xml:",any"`
+			}{}
+			for k, v := range blob.metadata {
+				item.Metadata.Items = append(item.Metadata.Items, xmlElement{XMLName: xml.Name{Local: k}, Value: v})
+			}
+		}
+		list.Blobs.Blob = append(list.Blobs.Blob, item)
+		count++
+	}
+
+	out, _ := xml.Marshal(list)
+	w.Header().Set(headerContentType, "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
+
+// xmlElement is a leaf element with an arbitrary name.
+type xmlElement struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
@@ -0,0 +1,159 @@
+package storagetest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type fakeContainer struct {
+	etag         string
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+func (f *fakeContainer) touch() {
+	f.etag = "\"0x" + strings.ToUpper(randomHex(8)) + "\""
+	f.lastModified = time.Now().UTC()
+}
+
+// serveContainer handles ?restype=container requests on a container.
+func (f *Server) serveContainer(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	container := f.containers[name]
+
+	if r.Method == http.MethodPut && query.Get("comp") == "" {
+		if container != nil {
+			writeStorageError(w, http.StatusConflict, "ContainerAlreadyExists", "The specified container already exists.")
+			return
+		}
+		container = &fakeContainer{metadata: metadataFromHeader(r.Header)}
+		container.touch()
+		f.containers[name] = container
+		f.blobs[name] = make(map[string]*fakeBlob)
+		w.Header().Set("ETag", container.etag)
+		w.Header().Set("Last-Modified", container.lastModified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if container == nil {
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		f.listBlobs(w, name, query)
+
+	case r.Method == http.MethodPut && query.Get("comp") == "metadata":
+		container.metadata = metadataFromHeader(r.Header)
+		container.touch()
+		w.Header().Set("ETag", container.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && (query.Get("comp") == "" || query.Get("comp") == "metadata"):
+		for k, v := range container.metadata {
+			w.Header().Set("x-ms-meta-"+k, v)
+		}
+		w.Header().Set("ETag", container.etag)
+		w.Header().Set("Last-Modified", container.lastModified.Format(http.TimeFormat))
+		if query.Get("comp") == "" {
+			w.Header().Set("x-ms-lease-status", "unlocked")
+			w.Header().Set("x-ms-lease-state", "available")
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodDelete && query.Get("comp") == "":
+		delete(f.containers, name)
+		delete(f.blobs, name)
+		for key := range f.uncommitted {
+			if strings.HasPrefix(key, name+"/") {
+				delete(f.uncommitted, key)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeStorageError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+	}
+}
+
+// listContainers handles GET /<account>/?comp=list.
+func (f *Server) listContainers(w http.ResponseWriter, query map[string][]string) {
+	get := func(key string) string {
+		if v := query[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, marker := get("prefix"), get("marker")
+	maxResults := queryInt(query, "maxresults", 5000)
+	includeMetadata := strings.Contains(get("include"), "metadata")
+
+	names := make([]string, 0, len(f.containers))
+	for name := range f.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type containerXML struct {
+		Name       string
+		Properties struct {
+			LastModified string `xml:"Last-Modified"`
+			Etag         string
+			LeaseStatus  string
+			LeaseState   string
+		}
+		Metadata *struct {
+			Items []xmlElement `xml:",any"`
+		} `xml:",omitempty"`
+	}
+	var list struct {
+		XMLName    xml.Name `xml:"EnumerationResults"`
+		Prefix     string   `xml:",omitempty"`
+		Marker     string   `xml:",omitempty"`
+		MaxResults int      `xml:",omitempty"`
+		Containers struct {
+			Container []containerXML
+		}
+		NextMarker string
+	}
+	list.Prefix, list.Marker = prefix, marker
+	if _, ok := query["maxresults"]; ok {
+		list.MaxResults = maxResults
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || name < marker {
+			continue
+		}
+		if len(list.Containers.Container) == maxResults {
+			list.NextMarker = name
+			break
+		}
+		container := f.containers[name]
+		item := containerXML{Name: name}
+		item.Properties.LastModified = container.lastModified.Format(http.TimeFormat)
+		item.Properties.Etag = container.etag
+		item.Properties.LeaseStatus = "unlocked"
+		item.Properties.LeaseState = "available"
+		if includeMetadata {
+			item.Metadata = &struct {
+				Items []xmlElement `xml:",any"`
+			}{}
+			for k, v := range container.metadata {
+				item.Metadata.Items = append(item.Metadata.Items, xmlElement{XMLName: xml.Name{Local: k}, Value: v})
+			}
+		}
+		list.Containers.Container = append(list.Containers.Container, item)
+	}
+
+	out, _ := xml.Marshal(list)
+	w.Header().Set(headerContentType, "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,101 @@
+package storagetest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"main/utils"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The headers the fake reads and writes.
+const (
+	headerAuthorization      = "Authorization"
+	headerCacheControl       = "Cache-Control"
+	headerContentEncoding    = "Content-Encoding"
+	headerContentDisposition = "Content-Disposition"
+	headerContentLanguage    = "Content-Language"
+	headerContentLength      = "Content-Length"
+	headerContentMD5         = "Content-MD5"
+	headerContentType        = "Content-Type"
+	headerIfMatch            = "If-Match"
+	headerIfNoneMatch        = "If-None-Match"
+	headerRange              = "Range"
+	headerXmsDate            = "x-ms-date"
+	headerXmsVersion         = "x-ms-version"
+)
+
+type queueMessagesList struct {
+	XMLName  xml.Name             `xml:"QueueMessagesList"`
+	Messages []utils.QueueMessage `xml:"QueueMessage"`
+}
+
+type blobPropertiesXML struct {
+	CreationTime       string `xml:"Creation-Time"`
+	LastModified       string `xml:"Last-Modified"`
+	ETag               string `xml:"Etag"`
+	ContentLength      int64  `xml:"Content-Length"`
+	ContentType        string `xml:"Content-Type"`
+	ContentEncoding    string `xml:"Content-Encoding"`
+	ContentLanguage    string `xml:"Content-Language"`
+	ContentDisposition string `xml:"Content-Disposition"`
+	CacheControl       string `xml:"Cache-Control"`
+	ContentMD5         string `xml:"Content-MD5"`
+	BlobType           string `xml:"BlobType"`
+	AccessTier         string `xml:"AccessTier"`
+	LeaseStatus        string `xml:"LeaseStatus"`
+	LeaseState         string `xml:"LeaseState"`
+}
+
+// blobTagsXML is the body of Get and Set Blob Tags.
+type blobTagsXML struct {
+	XMLName xml.Name `xml:"Tags"`
+	TagSet  []tagXML `xml:"TagSet>Tag"`
+}
+
+type tagXML struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+func newBlobTagsXML(tags map[string]string) blobTagsXML {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var body blobTagsXML
+	for _, k := range keys {
+		body.TagSet = append(body.TagSet, tagXML{Key: k, Value: tags[k]})
+	}
+	return body
+}
+
+func (f blobTagsXML) tags() map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range f.TagSet {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
+
+// metadataFromHeader collects x-ms-meta-* headers. Names are returned in
+// lower case because HTTP header names are not case-preserving.
+func metadataFromHeader(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-ms-meta-") && len(v) > 0 {
+			metadata[strings.TrimPrefix(name, "x-ms-meta-")] = v[0]
+		}
+	}
+	return metadata
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
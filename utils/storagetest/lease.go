@@ -0,0 +1,175 @@
+package storagetest
+
+import (
+	"main/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// fakeLease is the lease state of a fake blob. A zero value is available.
+type fakeLease struct {
+	id         string
+	infinite   bool
+	duration   time.Duration
+	expires    time.Time
+	breaking   bool
+	breakUntil time.Time
+}
+
+// state reports the lease state as returned in x-ms-lease-state.
+func (f *fakeLease) state(now time.Time) string {
+	switch {
+	case f.id == "":
+		return "available"
+	case f.breaking && now.Before(f.breakUntil):
+		return "breaking"
+	case f.breaking:
+		return "broken"
+	case f.infinite || now.Before(f.expires):
+		return "leased"
+	}
+	return "expired"
+}
+
+// active reports whether writes require the lease ID.
+func (f *fakeLease) active(now time.Time) bool {
+	state := f.state(now)
+	return state == "leased" || state == "breaking"
+}
+
+func (f *fakeLease) writeHeaders(w http.ResponseWriter) {
+	now := time.Now()
+	h := w.Header()
+	h.Set("x-ms-lease-state", f.state(now))
+	if f.active(now) {
+		h.Set("x-ms-lease-status", "locked")
+		if f.infinite {
+			h.Set("x-ms-lease-duration", "infinite")
+		} else {
+			h.Set("x-ms-lease-duration", "fixed")
+		}
+		return
+	}
+	h.Set("x-ms-lease-status", "unlocked")
+}
+
+// leaseAllowsWrite rejects writes to a leased blob that do not carry the
+// matching lease ID.
+func leaseAllowsWrite(w http.ResponseWriter, r *http.Request, blob *fakeBlob) bool {
+	leaseID := r.Header.Get("x-ms-lease-id")
+	if blob == nil || !blob.lease.active(time.Now()) {
+		if leaseID != "" {
+			writeStorageError(w, http.StatusPreconditionFailed, "LeaseNotPresentWithBlobOperation", "There is currently no lease on the blob.")
+			return false
+		}
+		return true
+	}
+	if leaseID == "" {
+		writeStorageError(w, http.StatusPreconditionFailed, "LeaseIdMissing", "There is currently a lease on the blob and no lease ID was specified in the request.")
+		return false
+	}
+	if leaseID != blob.lease.id {
+		writeStorageError(w, http.StatusPreconditionFailed, "LeaseIdMismatchWithBlobOperation", "The lease ID specified did not match the lease ID for the blob.")
+		return false
+	}
+	return true
+}
+
+// serveLease handles PUT ?comp=lease on an existing blob.
+func serveLease(w http.ResponseWriter, r *http.Request, blob *fakeBlob) {
+	now := time.Now()
+	lease := &blob.lease
+	state := lease.state(now)
+	leaseID := r.Header.Get("x-ms-lease-id")
+	proposed := r.Header.Get("x-ms-proposed-lease-id")
+
+	switch r.Header.Get("x-ms-lease-action") {
+	case "acquire":
+		duration, err := strconv.Atoi(r.Header.Get("x-ms-lease-duration"))
+		if err != nil || (duration != utils.InfiniteLease && (duration < 15 || duration > 60)) {
+			writeStorageError(w, http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+			return
+		}
+		if state == "breaking" || (state == "leased" && proposed != lease.id) {
+			writeStorageError(w, http.StatusConflict, "LeaseAlreadyPresent", "There is already a lease present.")
+			return
+		}
+		if proposed == "" {
+			proposed = newFakeLeaseID()
+		}
+		length := time.Duration(duration) * time.Second
+		*lease = fakeLease{id: proposed, infinite: duration == utils.InfiniteLease, duration: length, expires: now.Add(length)}
+		w.Header().Set("x-ms-lease-id", lease.id)
+		w.WriteHeader(http.StatusCreated)
+
+	case "renew":
+		if leaseID == "" || leaseID != lease.id {
+			writeStorageError(w, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation", "The lease ID specified did not match the lease ID for the blob.")
+			return
+		}
+		if state == "breaking" || state == "broken" {
+			writeStorageError(w, http.StatusConflict, "LeaseIsBrokenAndCannotBeRenewed", "The lease ID matched, but the lease has been broken explicitly and cannot be renewed.")
+			return
+		}
+		lease.expires = now.Add(lease.duration)
+		w.Header().Set("x-ms-lease-id", lease.id)
+		w.WriteHeader(http.StatusOK)
+
+	case "change":
+		if state != "leased" || (leaseID != lease.id && proposed != lease.id) {
+			writeStorageError(w, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation", "The lease ID specified did not match the lease ID for the blob.")
+			return
+		}
+		lease.id = proposed
+		w.Header().Set("x-ms-lease-id", lease.id)
+		w.WriteHeader(http.StatusOK)
+
+	case "release":
+		if leaseID == "" || leaseID != lease.id {
+			writeStorageError(w, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation", "The lease ID specified did not match the lease ID for the blob.")
+			return
+		}
+		*lease = fakeLease{}
+		w.WriteHeader(http.StatusOK)
+
+	case "break":
+		if state != "leased" && state != "breaking" {
+			writeStorageError(w, http.StatusConflict, "LeaseNotPresentWithLeaseOperation", "There is currently no lease on the blob.")
+			return
+		}
+		until := lease.expires
+		if lease.infinite {
+			until = now
+		}
+		if period := r.Header.Get("x-ms-lease-break-period"); period != "" {
+			seconds, err := strconv.Atoi(period)
+			if err != nil || seconds < 0 || seconds > 60 {
+				writeStorageError(w, http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+				return
+			}
+			if requested := now.Add(time.Duration(seconds) * time.Second); lease.infinite || requested.Before(until) {
+				until = requested
+			}
+		}
+		if state == "breaking" && lease.breakUntil.Before(until) {
+			until = lease.breakUntil
+		}
+		lease.breaking = true
+		lease.breakUntil = until
+		remaining := int((until.Sub(now) + time.Second - 1) / time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("x-ms-lease-time", strconv.Itoa(remaining))
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeStorageError(w, http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+	}
+}
+
+func newFakeLeaseID() string {
+	id := randomHex(16)
+	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:32]
+}
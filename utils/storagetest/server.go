@@ -0,0 +1,225 @@
+// Package storagetest runs an in-process fake of the Azure Queue and Blob
+// services for tests.
+package storagetest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-process stand-in for the Azure Queue and Blob REST
+// APIs, for tests that should run offline. Every request must carry a valid
+// SharedKey signature for the fake account, so the signing code is
+// exercised as well. Queues are created on first use; containers, as in
+// Azure, must be created before blobs are written to them.
+type Server struct {
+	QueueServer *httptest.Server
+	BlobServer  *httptest.Server
+
+	credential *utils.SharedKeyCredential
+	accountKey string
+
+	lock       sync.Mutex
+	queues     map[string]*utils.MemoryQueue
+	containers map[string]*fakeContainer
+	blobs      map[string]map[string]*fakeBlob
+	// uncommitted holds staged blocks by container/blob and block ID.
+	uncommitted map[string]map[string][]byte
+}
+
+func NewServer() *Server {
+	key := make([]byte, 64)
+	_, _ = rand.Read(key)
+
+	f := &Server{
+		accountKey:  base64.StdEncoding.EncodeToString(key),
+		queues:      make(map[string]*utils.MemoryQueue),
+		containers:  make(map[string]*fakeContainer),
+		blobs:       make(map[string]map[string]*fakeBlob),
+		uncommitted: make(map[string]map[string][]byte),
+	}
+	f.QueueServer = httptest.NewServer(f.authorized(f.serveQueue))
+	f.BlobServer = httptest.NewServer(f.authorized(f.serveBlob))
+	f.credential, _ = utils.NewSharedKeyCredential(f.ConnectionString())
+	return f
+}
+
+// AccountName is the storage account name the fake serves.
+func (f *Server) AccountName() string {
+	return "devstoreaccount1"
+}
+
+// ConnectionString points the utils package at the fake.
+func (f *Server) ConnectionString() string {
+	return "DefaultEndpointsProtocol=http;AccountName=" + f.AccountName() + ";AccountKey=" + f.accountKey +
+		";QueueEndpoint=" + f.QueueServer.URL + "/" + f.AccountName() +
+		";BlobEndpoint=" + f.BlobServer.URL + "/" + f.AccountName()
+}
+
+func (f *Server) Close() {
+	f.QueueServer.Close()
+	f.BlobServer.Close()
+}
+
+// authorized rejects requests whose Authorization header does not match
+// the signature computed by utils.SharedKeyCredential.StringToSign.
+func (f *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-request-id", randomHex(16))
+		w.Header().Set(headerXmsVersion, "2020-04-08")
+
+		if r.Header.Get(headerXmsDate) == "" {
+			writeStorageError(w, http.StatusForbidden, "AuthenticationFailed", "The x-ms-date header is required.")
+			return
+		}
+
+		signed := *r
+		signed.Header = r.Header.Clone()
+		if r.ContentLength > 0 {
+			signed.Header.Set(headerContentLength, strconv.FormatInt(r.ContentLength, 10))
+		}
+		stringToSign, err := f.credential.StringToSign(&signed)
+		if err != nil {
+			writeStorageError(w, http.StatusForbidden, "AuthenticationFailed", err.Error())
+			return
+		}
+		expected := "SharedKey " + f.AccountName() + ":" + f.credential.ComputeHMACSHA256(stringToSign)
+		if !hmac.Equal([]byte(r.Header.Get(headerAuthorization)), []byte(expected)) {
+			writeStorageError(w, http.StatusForbidden, "AuthenticationFailed", "Server failed to authenticate the request. The string to sign was: "+stringToSign)
+			return
+		}
+
+		if !strings.HasPrefix(r.URL.Path, "/"+f.AccountName()+"/") {
+			writeStorageError(w, http.StatusBadRequest, "InvalidUri", "The requested URI does not represent any resource on the server.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// serveQueue handles /<account>/<queue>[/messages[/<id>]].
+func (f *Server) serveQueue(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"+f.AccountName()+"/"), "/")
+	query := r.URL.Query()
+	q := f.queue(parts[0])
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet && query.Get("comp") == "metadata":
+		count, _ := q.Count()
+		w.Header().Set("x-ms-approximate-messages-count", strconv.Itoa(count))
+		w.WriteHeader(http.StatusOK)
+
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodPost:
+		text, ok := readFakeMessageText(r)
+		if !ok {
+			writeStorageError(w, http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+			return
+		}
+		messageId, _ := q.Send(text, time.Duration(queryInt(query, "visibilitytimeout", 0))*time.Second)
+		msg, _ := q.Message(messageId)
+		msg.MessageText = ""
+		msg.DequeueCount = 0
+		writeQueueMessages(w, http.StatusCreated, []utils.QueueMessage{msg})
+
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodGet:
+		count := queryInt(query, "numofmessages", 1)
+		if query.Get("peekonly") == "true" {
+			messages, _ := q.Peek(count)
+			writeQueueMessages(w, http.StatusOK, messages)
+			return
+		}
+		messages, _ := q.Receive(count, time.Duration(queryInt(query, "visibilitytimeout", 30))*time.Second)
+		writeQueueMessages(w, http.StatusOK, messages)
+
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodDelete:
+		_ = q.Clear()
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 3 && parts[1] == "messages" && r.Method == http.MethodDelete:
+		if err := q.Delete(parts[2], query.Get("popreceipt")); err != nil {
+			writeStorageError(w, http.StatusNotFound, "MessageNotFound", "The specified message does not exist.")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 3 && parts[1] == "messages" && r.Method == http.MethodPut:
+		text, ok := readFakeMessageText(r)
+		if !ok {
+			writeStorageError(w, http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+			return
+		}
+		popReceipt, err := q.Update(parts[2], query.Get("popreceipt"), text, time.Duration(queryInt(query, "visibilitytimeout", 0))*time.Second)
+		if err != nil {
+			writeStorageError(w, http.StatusNotFound, "MessageNotFound", "The specified message does not exist.")
+			return
+		}
+		w.Header().Set("x-ms-popreceipt", popReceipt)
+		msg, _ := q.Message(parts[2])
+		w.Header().Set("x-ms-time-next-visible", msg.TimeNextVisible)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeStorageError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+	}
+}
+
+func (f *Server) queue(queueName string) *utils.MemoryQueue {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.queues[queueName] == nil {
+		f.queues[queueName] = utils.NewMemoryQueue()
+	}
+	return f.queues[queueName]
+}
+
+func readFakeMessageText(r *http.Request) (string, bool) {
+	var body struct {
+		XMLName     xml.Name `xml:"QueueMessage"`
+		MessageText string
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil || xml.Unmarshal(data, &body) != nil {
+		return "", false
+	}
+	return body.MessageText, true
+}
+
+func writeQueueMessages(w http.ResponseWriter, status int, messages []utils.QueueMessage) {
+	out, _ := xml.Marshal(queueMessagesList{Messages: messages})
+	if len(messages) == 0 {
+		out = []byte("<QueueMessagesList />")
+	}
+	w.Header().Set(headerContentType, "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
+
+func writeStorageError(w http.ResponseWriter, status int, code string, message string) {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(message))
+	w.Header().Set(headerContentType, "application/xml")
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header + "<Error><Code>" + code + "</Code><Message>" + buf.String() + "</Message></Error>"))
+}
+
+func queryInt(query map[string][]string, key string, def int) int {
+	if v, ok := query[key]; ok && len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			return n
+		}
+	}
+	return def
+}
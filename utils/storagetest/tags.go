@@ -0,0 +1,155 @@
+package storagetest
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	fakeTagAnd     = regexp.MustCompile(`(?i)\s+AND\s+`)
+	fakeTagPattern = regexp.MustCompile(`^\s*("[^"]+"|@container)\s*(>=|<=|=|>|<)\s*'([^']*)'\s*$`)
+)
+
+type fakeTagClause struct {
+	key   string
+	op    string
+	value string
+}
+
+// parseTagQuery parses the subset of the blob tag query language made of
+// comparisons joined by AND.
+func parseTagQuery(where string) ([]fakeTagClause, bool) {
+	var clauses []fakeTagClause
+	for _, part := range fakeTagAnd.Split(where, -1) {
+		m := fakeTagPattern.FindStringSubmatch(part)
+		if m == nil {
+			return nil, false
+		}
+		clauses = append(clauses, fakeTagClause{key: strings.Trim(m[1], `"`), op: m[2], value: m[3]})
+	}
+	return clauses, len(clauses) > 0
+}
+
+func (f fakeTagClause) match(container string, tags map[string]string) bool {
+	actual, ok := tags[f.key]
+	if f.key == "@container" {
+		actual, ok = container, true
+	}
+	if !ok {
+		return false
+	}
+	switch f.op {
+	case "=":
+		return actual == f.value
+	case ">":
+		return actual > f.value
+	case ">=":
+		return actual >= f.value
+	case "<":
+		return actual < f.value
+	}
+	return actual <= f.value
+}
+
+// serveTags handles ?comp=tags on an existing blob.
+func serveTags(w http.ResponseWriter, r *http.Request, blob *fakeBlob) {
+	if r.Method == http.MethodPut {
+		body, _ := ioutil.ReadAll(r.Body)
+		var tags blobTagsXML
+		if err := xml.Unmarshal(body, &tags); err != nil {
+			writeStorageError(w, http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+			return
+		}
+		blob.tags = tags.tags()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	out, _ := xml.Marshal(newBlobTagsXML(blob.tags))
+	w.Header().Set(headerContentType, "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
+
+// tagsFromHeader reads the x-ms-tags header of Put Blob.
+func tagsFromHeader(header http.Header) map[string]string {
+	tags := make(map[string]string)
+	values, _ := url.ParseQuery(header.Get("x-ms-tags"))
+	for k, v := range values {
+		tags[k] = v[0]
+	}
+	return tags
+}
+
+// findBlobs handles GET /<account>/?comp=blobs.
+func (f *Server) findBlobs(w http.ResponseWriter, query url.Values) {
+	clauses, ok := parseTagQuery(query.Get("where"))
+	if !ok {
+		writeStorageError(w, http.StatusBadRequest, "InvalidQueryParameterValue", "Value for one of the query parameters specified in the request URI is invalid.")
+		return
+	}
+	maxResults := queryInt(query, "maxresults", 5000)
+	marker := query.Get("marker")
+
+	type found struct {
+		key       string
+		container string
+		name      string
+		tags      map[string]string
+	}
+	var matches []found
+	for container, blobs := range f.blobs {
+		for name, blob := range blobs {
+			tags := make(map[string]string)
+			matched := true
+			for _, clause := range clauses {
+				if !clause.match(container, blob.tags) {
+					matched = false
+					break
+				}
+				if clause.key != "@container" {
+					tags[clause.key] = blob.tags[clause.key]
+				}
+			}
+			if matched {
+				matches = append(matches, found{key: container + "/" + name, container: container, name: name, tags: tags})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].key < matches[j].key })
+
+	type blobXML struct {
+		Name          string
+		ContainerName string
+		Tags          blobTagsXML
+	}
+	var list struct {
+		XMLName    xml.Name `xml:"EnumerationResults"`
+		Where      string
+		Blobs      struct{ Blob []blobXML }
+		NextMarker string
+	}
+	list.Where = query.Get("where")
+	for _, m := range matches {
+		if m.key < marker {
+			continue
+		}
+		if len(list.Blobs.Blob) == maxResults {
+			list.NextMarker = m.key
+			break
+		}
+		list.Blobs.Blob = append(list.Blobs.Blob, blobXML{Name: m.name, ContainerName: m.container, Tags: newBlobTagsXML(m.tags)})
+	}
+
+	out, _ := xml.Marshal(list)
+	w.Header().Set(headerContentType, "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"main/processor"
+	"main/scheduler"
+	"main/utils"
+	"time"
+)
+
+func runWorker(args []string) error {
+	fs, qf := newFlagSet("worker")
+	scheduleFile := fs.String("schedule", GetScheduleFile(), "scheduler configuration file (default $SCHEDULE_FILE)")
+	leaderLock := fs.String("leader-lock", GetLeaderLock(), "<container>/<blob> leased so only one worker runs the scheduler (default $LEADER_LOCK)")
+	checkpointStore := fs.String("checkpoints", GetCheckpointStore(), "checkpoint store, blob:<container> or sql:<table> (default $CHECKPOINT_STORE)")
+	logConfig := fs.String("log-config", GetLogConfig(), "JSON file choosing the log sinks of each queue (default $LOG_CONFIG)")
+	retryConfig := fs.String("retry-config", GetRetryConfig(), "JSON file with the retry policy of each processor type (default $RETRY_CONFIG)")
+	deleteClaimCheck := fs.Bool("delete-claim-check", GetDeleteClaimCheck(), "delete claim-check blobs after a job succeeds (default $DELETE_CLAIM_CHECK)")
+	if err := qf.parse(fs, args); err != nil {
+		return err
+	}
+	connString, queueName := qf.connString, qf.queueName
+
+	if *logConfig != "" {
+		config, err := processor.LoadLogConfig(*logConfig)
+		if err != nil {
+			return err
+		}
+		processor.JobLogConfig = config
+	}
+
+	if *retryConfig != "" {
+		policies, err := processor.LoadRetryPolicies(*retryConfig)
+		if err != nil {
+			return err
+		}
+		processor.RetryPolicies = policies
+	}
+	// retries of plain messages are sealed like new ones
+	retryOptions := utils.MessageOptions{
+		ClaimCheckContainer: GetClaimCheckContainer(),
+		Codec:               GetMessageCodec(),
+		Encrypt:             GetEncryptMessages(),
+	}
+	if retryOptions.ClaimCheckContainer != "" && utils.IsLocalBackend(connString) {
+		return utils.ErrClaimCheckBackend
+	}
+
+	var checkpoints processor.CheckpointStore
+	if *checkpointStore != "" {
+		var err error
+		if checkpoints, err = processor.OpenCheckpointStore(*checkpointStore, connString, utils.GetSQLConnectString()); err != nil {
+			return err
+		}
+	}
+
+	if *scheduleFile != "" {
+		s, err := scheduler.Load(*scheduleFile, connString)
+		if err != nil {
+			return err
+		}
+		if *leaderLock == "" {
+			go s.Run(context.Background())
+		} else {
+			lock, err := newLeaderLock(connString, *leaderLock)
+			if err != nil {
+				return err
+			}
+			s.Lock = lock
+			go lock.RunAsLeader(context.Background(), func(ctx context.Context) {
+				fmt.Println("scheduler: leadership acquired")
+				if err := s.Run(ctx); err != nil && ctx.Err() == nil {
+					fmt.Println(err.Error())
+				}
+				fmt.Println("scheduler: leadership released")
+			})
+		}
+	}
+
+	for {
+		time.Sleep(time.Second * 5)
+
+		go func() {
+			// the message stays in the queue, hidden, until the job ends,
+			// so it is redelivered if this process dies
+			msg, lease, err := utils.ReceiveMessage(connString, queueName)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+			if msg != nil {
+
+				var req processor.QueueRequest
+				if err := json.Unmarshal([]byte(msg.Body), &req); err != nil {
+					// retrying won't fix the body; keep it, as received, for Replay
+					fmt.Println("message " + msg.MessageId + ": " + err.Error())
+					if err := lease.DeadLetter(connString, utils.DeadLetterQueue(queueName)); err != nil {
+						fmt.Println(err.Error())
+						lease.Release()
+					}
+					return
+				}
+				go func() {
+					p := processor.NewCurrencyConversionSyncProcessor(req)
+					p.SetMessage(msg)
+					if checkpoints != nil {
+						p.SetCheckpointStore(checkpoints)
+					}
+					if err := p.Start(p); err != nil {
+						if err := processor.Retry(connString, queueName, msg, processor.RetryPolicyFor(p), retryOptions); err != nil {
+							// redelivered once it becomes visible again
+							fmt.Println(err.Error())
+							lease.Release()
+							return
+						}
+						if err := lease.Complete(); err != nil {
+							fmt.Println(err.Error())
+						}
+						return
+					}
+					if err := lease.Complete(); err != nil {
+						fmt.Println(err.Error())
+					}
+
+					if *deleteClaimCheck {
+						if err := utils.DeleteClaimCheck(connString, msg); err != nil {
+							fmt.Println(err.Error())
+						}
+					}
+				}()
+			}
+		}()
+
+	}
+}